@@ -0,0 +1,59 @@
+// Package checklist parses checklist items written in Markdown task list
+// syntax, e.g. "- [ ] buy milk" or "* [x] call mom", out of text.
+package checklist
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Item is a single checklist item. Index is the position of the item among
+// all items in the text, counting from zero.
+type Item struct {
+	Index   int64  `json:"index"`
+	Text    string `json:"text"`
+	Checked bool   `json:"checked"`
+}
+
+var reItem = regexp.MustCompile(`^(\s*[-*+]\s+\[)([ xX])(\](?:\s+(.*))?)$`)
+
+// Parse returns the checklist items in the given text in order.
+func Parse(text string) []Item {
+	var items []Item
+	for _, line := range strings.Split(text, "\n") {
+		m := reItem.FindStringSubmatch(strings.TrimSuffix(line, "\r"))
+		if m == nil {
+			continue
+		}
+		items = append(items, Item{
+			Index:   int64(len(items)),
+			Text:    strings.TrimSpace(m[4]),
+			Checked: m[2] != " ",
+		})
+	}
+	return items
+}
+
+// Toggle returns the text with the checklist item at the given index checked
+// or unchecked. It reports false when there's no item at the index.
+func Toggle(text string, index int64) (string, bool) {
+	lines := strings.Split(text, "\n")
+	var n int64
+	for i, line := range lines {
+		trimmed := strings.TrimSuffix(line, "\r")
+		m := reItem.FindStringSubmatchIndex(trimmed)
+		if m == nil {
+			continue
+		}
+		if n == index {
+			mark := "x"
+			if trimmed[m[4]:m[5]] != " " {
+				mark = " "
+			}
+			lines[i] = line[:m[4]] + mark + line[m[5]:]
+			return strings.Join(lines, "\n"), true
+		}
+		n++
+	}
+	return text, false
+}
@@ -0,0 +1,42 @@
+package checklist
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	text := "Groceries\n- [ ] milk\n- [x] eggs\r\n  * [X]   bread  \n-[ ] not an item\n- [ ]"
+	items := Parse(text)
+	if len(items) != 4 {
+		t.Fatalf("items != 4 (%+v)", items)
+	}
+	expected := []Item{
+		{0, "milk", false},
+		{1, "eggs", true},
+		{2, "bread", true},
+		{3, "", false},
+	}
+	for i, item := range items {
+		if item != expected[i] {
+			t.Errorf("item %d: expected %+v, got %+v", i, expected[i], item)
+		}
+	}
+	if items := Parse("no items here"); items != nil {
+		t.Errorf("expected no items (%+v)", items)
+	}
+}
+
+func TestToggle(t *testing.T) {
+	text := "Groceries\n- [ ] milk\n- [x] eggs"
+	out, ok := Toggle(text, 0)
+	if !ok || out != "Groceries\n- [x] milk\n- [x] eggs" {
+		t.Errorf("unexpected toggle (%q)", out)
+	}
+	out, ok = Toggle(out, 1)
+	if !ok || out != "Groceries\n- [x] milk\n- [ ] eggs" {
+		t.Errorf("unexpected toggle (%q)", out)
+	}
+	if _, ok := Toggle(text, 2); ok {
+		t.Errorf("expected missing item")
+	}
+}
@@ -2,14 +2,20 @@ package documents
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3" // driver
+	"github.com/nathanborror/logger/pkg/migrate"
+	"github.com/nathanborror/logger/pkg/seal"
+	"github.com/nathanborror/logger/pkg/wikilink"
 )
 
 // Documents represents the interface for interacting with Documents.
 type Documents struct {
-	db *sqlx.DB
+	db      *sqlx.DB
+	keyring *seal.Keyring // set when documents are sealed
+	box     *seal.Box     // set while unlocked
 }
 
 // New returns a database interface for interacting with Documents.
@@ -21,50 +27,87 @@ func New(name string) (*Documents, error) {
 	if err = conn.Ping(); err != nil {
 		return nil, err
 	}
-	if _, err := conn.Exec(`
-		CREATE TABLE IF NOT EXISTS document (
-			document    TEXT NOT NULL,
-			identifier  TEXT GENERATED ALWAYS AS (json_extract(document, '$.identifier')) VIRTUAL NOT NULL UNIQUE,
-			contentType TEXT GENERATED ALWAYS AS (json_extract(document, '$.content.meta.contentType')) VIRTUAL NOT NULL,
-			tags        TEXT GENERATED ALWAYS AS (json_extract(document, '$.content.meta.tags')) VIRTUAL,
-			created     DATETIME GENERATED ALWAYS AS (json_extract(document, '$.content.created')) VIRTUAL NOT NULL,
-			modified    DATETIME GENERATED ALWAYS AS (json_extract(document, '$.content.modified')) VIRTUAL NOT NULL
-		);
 
-		CREATE VIRTUAL TABLE IF NOT EXISTS search_document_tags USING fts5(tags);
-		PRAGMA RECURSIVE_TRIGGERS = true;
-		CREATE TRIGGER IF NOT EXISTS after_document_insert AFTER INSERT ON document BEGIN
-			INSERT INTO search_document_tags (rowid, tags) VALUES (new.rowid, new.tags);
-		END;
-		CREATE TRIGGER IF NOT EXISTS after_document_delete AFTER DELETE ON document BEGIN
-			DELETE FROM search_document_tags WHERE rowid = old.rowid;
-		END;
-	`); err != nil {
+	// SQLite only allows a single writer and every connection to an in-memory
+	// database is a separate database, so share one connection.
+	conn.SetMaxOpenConns(1)
+
+	if _, err := conn.Exec(`PRAGMA recursive_triggers = true`); err != nil {
+		return nil, err
+	}
+	if err := migrate.Run(conn, migrations); err != nil {
 		return nil, err
 	}
-	return &Documents{db: conn.Unsafe()}, nil
+	d := &Documents{db: conn.Unsafe()}
+	if err := d.loadKeyring(); err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
-// Documents returns all documents.
+// Close closes the underlying database.
+func (d *Documents) Close() error {
+	return d.db.Close()
+}
+
+// Documents returns all documents, pinned documents first.
 func (d *Documents) Documents() ([]Document, error) {
 	var strs []string
-	if err := d.db.Select(&strs, `SELECT document FROM document ORDER BY created DESC`); err != nil {
+	if err := d.db.Select(&strs, `SELECT document FROM document WHERE deleted IS NULL ORDER BY pinned IS NULL, pinned DESC, created DESC`); err != nil {
+		return nil, err
+	}
+	return d.decodeAll(strs)
+}
+
+// DocumentsBefore returns up to limit unpinned documents positioned after the
+// document with the given created time and identifier, newest first. A zero
+// created time starts at the most recent document and a limit of zero or less
+// returns all remaining documents.
+func (d *Documents) DocumentsBefore(created time.Time, identifier string, limit int64) ([]Document, error) {
+	var strs []string
+	if limit <= 0 {
+		limit = -1
+	}
+	if created.IsZero() {
+		if err := d.db.Select(&strs, `SELECT document FROM document WHERE deleted IS NULL AND pinned IS NULL ORDER BY created DESC, identifier DESC LIMIT ?`, limit); err != nil {
+			return nil, err
+		}
+		return d.decodeAll(strs)
+	}
+	position := created.Format(time.RFC3339Nano)
+	if err := d.db.Select(&strs, `
+		SELECT document FROM document
+		WHERE deleted IS NULL AND pinned IS NULL AND (created < $1 OR (created = $1 AND identifier < $2))
+		ORDER BY created DESC, identifier DESC
+		LIMIT $3`, position, identifier, limit); err != nil {
 		return nil, err
 	}
-	return DecodeDocuments(strs)
+	return d.decodeAll(strs)
+}
+
+// DocumentsPinned returns all pinned documents, most recently pinned first.
+func (d *Documents) DocumentsPinned() ([]Document, error) {
+	var strs []string
+	if err := d.db.Select(&strs, `SELECT document FROM document WHERE deleted IS NULL AND pinned IS NOT NULL ORDER BY pinned DESC`); err != nil {
+		return nil, err
+	}
+	return d.decodeAll(strs)
 }
 
 // DocumentsForContentType returns all documents for a given content-type.
 func (d *Documents) DocumentsForContentType(contentType string) ([]Document, error) {
 	var strs []string
-	if err := d.db.Select(&strs, `SELECT document FROM document WHERE contentType = ? ORDER BY created DESC`, contentType); err != nil {
+	if err := d.db.Select(&strs, `SELECT document FROM document WHERE contentType = ? AND deleted IS NULL ORDER BY created DESC`, contentType); err != nil {
 		return nil, err
 	}
-	return DecodeDocuments(strs)
+	return d.decodeAll(strs)
 }
 
 // DocumentsForTag returns all documents matching the given tag.
 func (d *Documents) DocumentsForTag(tag string) ([]Document, error) {
+	if d.Encrypted() {
+		return d.sealedDocumentsForTag(tag)
+	}
 	var (
 		ids  []int64
 		strs []string
@@ -75,14 +118,52 @@ func (d *Documents) DocumentsForTag(tag string) ([]Document, error) {
 	if len(ids) == 0 {
 		return nil, sql.ErrNoRows
 	}
-	query, args, err := sqlx.In(`SELECT document FROM document WHERE rowid IN (?) ORDER BY created DESC`, ids)
+	query, args, err := sqlx.In(`SELECT document FROM document WHERE rowid IN (?) AND deleted IS NULL ORDER BY created DESC`, ids)
 	if err != nil {
 		return nil, err
 	}
 	if err := d.db.Select(&strs, query, args...); err != nil {
 		return nil, err
 	}
-	return DecodeDocuments(strs)
+	return d.decodeAll(strs)
+}
+
+// Tags returns every tag used by documents with a count of documents using it
+// and when it was first and last used.
+func (d *Documents) Tags() ([]TagUsage, error) {
+	if d.Encrypted() {
+		return d.sealedTags()
+	}
+	var rows []struct {
+		Tag   string `db:"tag"`
+		Count int64  `db:"count"`
+		First string `db:"first"`
+		Last  string `db:"last"`
+	}
+	if err := d.db.Select(&rows, `
+		SELECT json_each.value AS tag,
+			count(DISTINCT document.identifier) AS count,
+			min(document.created) AS first,
+			max(document.modified) AS last
+		FROM document, json_each(document.tags)
+		WHERE document.deleted IS NULL
+		GROUP BY json_each.value
+		ORDER BY count DESC, tag`); err != nil {
+		return nil, err
+	}
+	out := make([]TagUsage, 0, len(rows))
+	for _, row := range rows {
+		first, err := time.Parse(time.RFC3339Nano, row.First)
+		if err != nil {
+			return nil, err
+		}
+		last, err := time.Parse(time.RFC3339Nano, row.Last)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, TagUsage{Tag: row.Tag, Count: row.Count, First: first, Last: last})
+	}
+	return out, nil
 }
 
 // DocumentForIdentifier returns a document for a given identifier.
@@ -91,7 +172,7 @@ func (d *Documents) DocumentForIdentifier(id string) (*Document, error) {
 	if err := d.db.Get(&str, `SELECT document FROM document WHERE identifier = ?`, id); err != nil {
 		return nil, err
 	}
-	return DecodeDocument(str)
+	return d.decode(str)
 }
 
 // DocumentSave returns a created or updated document, maintaining a history of edits.
@@ -103,12 +184,189 @@ func (d *Documents) DocumentSave(id string, content Content) error {
 		doc.History = append(doc.History, doc.Content)
 		doc.Content = content
 	}
-	_, err := d.db.Exec(`INSERT OR REPLACE INTO document (document) VALUES (?)`, doc.Serialize())
+	str, err := d.encode(doc)
+	if err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(`INSERT OR REPLACE INTO document (document) VALUES (?)`, str); err != nil {
+		return err
+	}
+	if d.Encrypted() {
+		return nil
+	}
+	return indexReferences(d.db, id, content.Text)
+}
+
+// DocumentTrash marks a document as deleted, hiding it from everything but
+// DocumentForIdentifier and DocumentsTrashed until it's restored or purged.
+func (d *Documents) DocumentTrash(id string) error {
+	if d.Locked() {
+		return ErrLocked
+	}
+	now := time.Now().Format(time.RFC3339Nano)
+	res, err := d.db.Exec(`UPDATE document SET document = json_set(document, '$.deleted', ?) WHERE identifier = ? AND deleted IS NULL`, now, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DocumentRestore moves a document out of the trash.
+func (d *Documents) DocumentRestore(id string) error {
+	if d.Locked() {
+		return ErrLocked
+	}
+	res, err := d.db.Exec(`UPDATE document SET document = json_remove(document, '$.deleted') WHERE identifier = ? AND deleted IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DocumentPin pins a document above all others.
+func (d *Documents) DocumentPin(id string) error {
+	if d.Locked() {
+		return ErrLocked
+	}
+	now := time.Now().Format(time.RFC3339Nano)
+	res, err := d.db.Exec(`UPDATE document SET document = json_set(document, '$.pinned', ?) WHERE identifier = ? AND deleted IS NULL`, now, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DocumentUnpin returns a pinned document to its chronological position.
+func (d *Documents) DocumentUnpin(id string) error {
+	if d.Locked() {
+		return ErrLocked
+	}
+	res, err := d.db.Exec(`UPDATE document SET document = json_remove(document, '$.pinned') WHERE identifier = ? AND deleted IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DocumentsTrashed returns all documents in the trash, most recently deleted first.
+func (d *Documents) DocumentsTrashed() ([]Document, error) {
+	var strs []string
+	if err := d.db.Select(&strs, `SELECT document FROM document WHERE deleted IS NOT NULL ORDER BY deleted DESC`); err != nil {
+		return nil, err
+	}
+	return d.decodeAll(strs)
+}
+
+// DocumentsPurge permanently removes documents moved to the trash before the given time.
+func (d *Documents) DocumentsPurge(before time.Time) error {
+	if _, err := d.db.Exec(`DELETE FROM document WHERE deleted IS NOT NULL AND deleted < ?`, before.Format(time.RFC3339Nano)); err != nil {
+		return err
+	}
+	_, err := d.db.Exec(`DELETE FROM document_reference WHERE source NOT IN (SELECT identifier FROM document)`)
 	return err
 }
 
+// Setting returns the value of a setting or an empty string when unset.
+func (d *Documents) Setting(key string) (string, error) {
+	var value string
+	err := d.db.Get(&value, `SELECT value FROM setting WHERE key = ?`, key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetSetting saves the value of a setting.
+func (d *Documents) SetSetting(key, value string) error {
+	_, err := d.db.Exec(`INSERT OR REPLACE INTO setting (key, value) VALUES (?, ?)`, key, value)
+	return err
+}
+
+// Changes returns a count that goes up whenever the database is changed,
+// whether through d or another connection.
+func (d *Documents) Changes() (int64, error) {
+	var n int64
+	err := d.db.Get(&n, `SELECT total_changes() + (SELECT data_version FROM pragma_data_version())`)
+	return n, err
+}
+
 // DocumentDelete removes a document from storage.
 func (d *Documents) DocumentDelete(id string) error {
-	_, err := d.db.Exec(`DELETE FROM document WHERE identifier = ?`, id)
+	if d.Locked() {
+		return ErrLocked
+	}
+	if _, err := d.db.Exec(`DELETE FROM document WHERE identifier = ?`, id); err != nil {
+		return err
+	}
+	_, err := d.db.Exec(`DELETE FROM document_reference WHERE source = ?`, id)
 	return err
 }
+
+// References returns the [[links]] from a document to other documents in the
+// order they appear. Links are resolved against documents outside the trash
+// when they're read, preferring an identifier over a title.
+func (d *Documents) References(id string) ([]Reference, error) {
+	if d.Encrypted() {
+		return d.sealedReferences(id)
+	}
+	refs := []Reference{}
+	err := d.db.Select(&refs, `
+		SELECT document_reference.position, document_reference.target, coalesce(
+			(SELECT document.identifier FROM document WHERE document.deleted IS NULL AND document.identifier = document_reference.target),
+			(SELECT document.identifier FROM document WHERE document.deleted IS NULL AND `+wikilink.TitleSQL(`json_extract(document.document, '$.content.text')`)+` = document_reference.target COLLATE NOCASE
+				ORDER BY document.created DESC LIMIT 1),
+			''
+		) AS identifier
+		FROM document_reference
+		WHERE document_reference.source = ?
+		ORDER BY document_reference.position`, id)
+	return refs, err
+}
+
+// DocumentsReferencing returns the documents linking to the given document by
+// identifier or title, newest first.
+func (d *Documents) DocumentsReferencing(id string) ([]Document, error) {
+	if d.Encrypted() {
+		return d.sealedDocumentsReferencing(id)
+	}
+	var strs []string
+	if err := d.db.Select(&strs, `
+		SELECT document FROM document
+		WHERE deleted IS NULL AND identifier != $1 AND identifier IN (
+			SELECT document_reference.source FROM document_reference, document AS target
+			WHERE target.identifier = $1 AND (
+				document_reference.target = target.identifier OR
+				document_reference.target = `+wikilink.TitleSQL(`json_extract(target.document, '$.content.text')`)+` COLLATE NOCASE
+			)
+		)
+		ORDER BY created DESC`, id); err != nil {
+		return nil, err
+	}
+	return d.decodeAll(strs)
+}
+
+// indexReferences replaces the indexed links from a document with those
+// found in text.
+func indexReferences(db sqlx.Execer, id string, text string) error {
+	if _, err := db.Exec(`DELETE FROM document_reference WHERE source = ?`, id); err != nil {
+		return err
+	}
+	for i, target := range wikilink.Parse(text) {
+		if _, err := db.Exec(`INSERT INTO document_reference (source, position, target) VALUES (?, ?, ?)`, id, i, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
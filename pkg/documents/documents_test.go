@@ -2,6 +2,7 @@ package documents
 
 import (
 	"testing"
+	"time"
 )
 
 func TestDocumentCreation(t *testing.T) {
@@ -133,3 +134,132 @@ func TestDocumentContentType(t *testing.T) {
 		t.Errorf("docs != 2 (%+v)", docs)
 	}
 }
+
+func TestDocumentsBefore(t *testing.T) {
+	db, _ := New(":memory:")
+	now := time.Now()
+	for i, id := range []string{"a", "b", "c"} {
+		content := Content{
+			Text:     id,
+			Created:  now.Add(time.Duration(i) * time.Second),
+			Modified: now,
+			Meta:     Meta{ContentType: "post"},
+		}
+		if err := db.DocumentSave(id, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	docs, err := db.DocumentsBefore(time.Time{}, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[0].Identifier != "c" {
+		t.Fatalf("unexpected first page (%+v)", docs)
+	}
+	last := docs[len(docs)-1]
+	docs, err = db.DocumentsBefore(last.Content.Created, last.Identifier, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Identifier != "a" {
+		t.Errorf("unexpected second page (%+v)", docs)
+	}
+}
+
+func TestTags(t *testing.T) {
+	db, _ := New(":memory:")
+	db.DocumentSave("a", Content{Text: "foo", Meta: Meta{ContentType: "post", Tags: []string{"foo"}}})
+	db.DocumentSave("b", Content{Text: "bar", Meta: Meta{ContentType: "post", Tags: []string{"foo", "bar"}}})
+
+	tags, err := db.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0].Tag != "foo" || tags[0].Count != 2 {
+		t.Errorf("unexpected tags (%+v)", tags)
+	}
+}
+
+func TestDocumentTrash(t *testing.T) {
+	db, _ := New(":memory:")
+	db.DocumentSave("a", Content{Text: "foo", Meta: Meta{ContentType: "post", Tags: []string{"foo"}}})
+	db.DocumentSave("b", Content{Text: "bar", Meta: Meta{ContentType: "post", Tags: []string{"foo"}}})
+
+	if err := db.DocumentTrash("a"); err != nil {
+		t.Fatal(err)
+	}
+	if docs, _ := db.Documents(); len(docs) != 1 {
+		t.Errorf("docs != 1 (%d)", len(docs))
+	}
+	if docs, _ := db.DocumentsForTag("foo"); len(docs) != 1 {
+		t.Errorf("tagged docs != 1 (%d)", len(docs))
+	}
+	if docs, _ := db.DocumentsTrashed(); len(docs) != 1 || docs[0].Deleted == nil {
+		t.Errorf("unexpected trash (%+v)", docs)
+	}
+	if err := db.DocumentRestore("a"); err != nil {
+		t.Fatal(err)
+	}
+	if docs, _ := db.Documents(); len(docs) != 2 {
+		t.Errorf("docs != 2 (%d)", len(docs))
+	}
+	db.DocumentTrash("a")
+	if err := db.DocumentsPurge(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DocumentForIdentifier("a"); err == nil {
+		t.Errorf("expected purged document to be gone")
+	}
+}
+
+func TestDocumentPin(t *testing.T) {
+	db, _ := New(":memory:")
+	db.DocumentSave("a", Content{Text: "foo", Created: time.Now().Add(-time.Hour), Meta: Meta{ContentType: "post"}})
+	db.DocumentSave("b", Content{Text: "bar", Created: time.Now(), Meta: Meta{ContentType: "post"}})
+
+	if err := db.DocumentPin("a"); err != nil {
+		t.Fatal(err)
+	}
+	if docs, _ := db.Documents(); len(docs) != 2 || docs[0].Identifier != "a" || docs[0].Pinned == nil {
+		t.Errorf("expected pinned document first (%+v)", docs)
+	}
+	if docs, _ := db.DocumentsPinned(); len(docs) != 1 {
+		t.Errorf("pinned docs != 1 (%d)", len(docs))
+	}
+	if docs, _ := db.DocumentsBefore(time.Time{}, "", 0); len(docs) != 1 || docs[0].Identifier != "b" {
+		t.Errorf("expected only unpinned documents (%+v)", docs)
+	}
+	db.DocumentSave("a", Content{Text: "foo bar", Meta: Meta{ContentType: "post"}})
+	if docs, _ := db.DocumentsPinned(); len(docs) != 1 {
+		t.Errorf("pin lost on save (%d)", len(docs))
+	}
+	if err := db.DocumentUnpin("a"); err != nil {
+		t.Fatal(err)
+	}
+	if docs, _ := db.DocumentsPinned(); len(docs) != 0 {
+		t.Errorf("pinned docs != 0 (%d)", len(docs))
+	}
+}
+
+func TestReferences(t *testing.T) {
+	db, _ := New(":memory:")
+	db.DocumentSave("a", Content{Text: "Groceries\nmilk", Meta: Meta{ContentType: "post"}})
+	db.DocumentSave("b", Content{Text: "see [[groceries]] and [[missing]]", Meta: Meta{ContentType: "post"}})
+	db.DocumentSave("c", Content{Text: "[[a]]", Meta: Meta{ContentType: "post"}})
+
+	refs, err := db.References("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Identifier != "a" || refs[1].Identifier != "" {
+		t.Errorf("unexpected references (%+v)", refs)
+	}
+	if docs, _ := db.DocumentsReferencing("a"); len(docs) != 2 {
+		t.Errorf("backlinks != 2 (%d)", len(docs))
+	}
+	db.DocumentSave("c", Content{Text: "no links", Meta: Meta{ContentType: "post"}})
+	db.DocumentDelete("b")
+	if docs, _ := db.DocumentsReferencing("a"); len(docs) != 0 {
+		t.Errorf("backlinks != 0 (%d)", len(docs))
+	}
+}
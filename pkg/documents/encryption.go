@@ -0,0 +1,373 @@
+package documents
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathanborror/logger/pkg/seal"
+	"github.com/nathanborror/logger/pkg/wikilink"
+)
+
+// Encryption
+//
+// Encrypted databases seal each document under a data key kept in a keyring
+// sealed with the user's passphrase. Only the fields the generated columns
+// need, the identifier, timestamps, content type and trash and pin times, are
+// left readable. Tags and references are derived from the unsealed documents
+// while the database is unlocked instead of being indexed.
+
+// settingEncryption holds the keyring of an encrypted database.
+const settingEncryption = "encryption"
+
+// ErrLocked is returned when using an encrypted database before it's unlocked.
+var ErrLocked = errors.New("database is locked")
+
+// sealedDocument is how a document is stored in an encrypted database.
+type sealedDocument struct {
+	Identifier string     `json:"identifier"`
+	Content    Content    `json:"content"`
+	Deleted    *time.Time `json:"deleted,omitempty"`
+	Pinned     *time.Time `json:"pinned,omitempty"`
+	Sealed     string     `json:"sealed"`
+}
+
+// Encrypted reports whether documents are sealed.
+func (d *Documents) Encrypted() bool {
+	return d.keyring != nil
+}
+
+// Locked reports whether documents are sealed and the database hasn't been
+// unlocked.
+func (d *Documents) Locked() bool {
+	return d.keyring != nil && d.box == nil
+}
+
+// Unlock opens the keyring with the given passphrase so sealed documents can
+// be read and written.
+func (d *Documents) Unlock(passphrase string) error {
+	if d.keyring == nil {
+		return nil
+	}
+	box, err := d.keyring.Unlock(passphrase)
+	if err != nil {
+		return err
+	}
+	d.box = box
+	return nil
+}
+
+// Lock forgets the data key until the database is unlocked again.
+func (d *Documents) Lock() {
+	d.box = nil
+}
+
+// SetPassphrase seals every document with the given passphrase. When the
+// database is already encrypted the current passphrase is required and
+// documents are resealed under a new data key. An empty passphrase unseals
+// every document.
+func (d *Documents) SetPassphrase(current, passphrase string) error {
+	var (
+		from *seal.Box
+		err  error
+	)
+	if d.keyring != nil {
+		if from, err = d.keyring.Unlock(current); err != nil {
+			return err
+		}
+	} else if passphrase == "" {
+		return nil
+	}
+	var (
+		keyring *seal.Keyring
+		to      *seal.Box
+	)
+	if passphrase != "" {
+		if keyring, to, err = seal.NewKeyring(passphrase); err != nil {
+			return err
+		}
+	}
+
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var strs []string
+	if err := tx.Select(&strs, `SELECT document FROM document`); err != nil {
+		return err
+	}
+	for _, str := range strs {
+		doc, err := decodeSealed(str, from)
+		if err != nil {
+			return err
+		}
+		// Replacing rather than updating keeps the tag index in step.
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO document (document) VALUES (?)`, encodeSealed(doc, to)); err != nil {
+			return err
+		}
+		if to == nil {
+			if err := indexReferences(tx, doc.Identifier, doc.Content.Text); err != nil {
+				return err
+			}
+		}
+	}
+	if to == nil {
+		if _, err := tx.Exec(`DELETE FROM setting WHERE key = ?`, settingEncryption); err != nil {
+			return err
+		}
+	} else {
+		data, err := json.Marshal(keyring)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO setting (key, value) VALUES (?, ?)`, settingEncryption, string(data)); err != nil {
+			return err
+		}
+	}
+	if from == nil {
+		// Deleting from the tag index only records tombstones, the tokens
+		// stay in its shadow tables until the segments are merged.
+		if _, err := tx.Exec(`
+			DELETE FROM document_reference;
+			INSERT INTO search_document_tags (search_document_tags) VALUES ('optimize');
+		`); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.keyring, d.box = keyring, to
+
+	// Rewrite the database file so no plain text remains in free pages.
+	if from == nil {
+		if _, err := d.db.Exec(`VACUUM`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadKeyring reads the keyring of an encrypted database, leaving it locked.
+func (d *Documents) loadKeyring() error {
+	data, err := d.Setting(settingEncryption)
+	if err != nil || data == "" {
+		return err
+	}
+	return json.Unmarshal([]byte(data), &d.keyring)
+}
+
+// encode returns the stored form of a document, sealing it when the database
+// is encrypted.
+func (d *Documents) encode(doc *Document) (string, error) {
+	if d.Locked() {
+		return "", ErrLocked
+	}
+	return encodeSealed(doc, d.box), nil
+}
+
+// decode returns the document stored in str, unsealing it when needed.
+func (d *Documents) decode(str string) (*Document, error) {
+	if d.Locked() {
+		return nil, ErrLocked
+	}
+	return decodeSealed(str, d.box)
+}
+
+func (d *Documents) decodeAll(strs []string) ([]Document, error) {
+	if d.Locked() {
+		return nil, ErrLocked
+	}
+	out := make([]Document, 0, len(strs))
+	for _, str := range strs {
+		doc, err := d.decode(str)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *doc)
+	}
+	return out, nil
+}
+
+// encodeSealed returns the stored form of a document sealed in box, or the
+// document itself when box is nil.
+func encodeSealed(doc *Document, box *seal.Box) string {
+	if box == nil {
+		return doc.Serialize()
+	}
+	stored := sealedDocument{
+		Identifier: doc.Identifier,
+		Content: Content{
+			Created:  doc.Content.Created,
+			Modified: doc.Content.Modified,
+			Meta:     Meta{ContentType: doc.Content.Meta.ContentType},
+		},
+		Deleted: doc.Deleted,
+		Pinned:  doc.Pinned,
+		Sealed:  box.SealString(doc.Serialize()),
+	}
+	out, _ := json.Marshal(stored)
+	return string(out)
+}
+
+// decodeSealed returns the document stored in str, opening it with box when
+// it's sealed. Trash and pin times are changed in place so those left
+// readable take precedence.
+func decodeSealed(str string, box *seal.Box) (*Document, error) {
+	var stored sealedDocument
+	if err := json.Unmarshal([]byte(str), &stored); err != nil {
+		return nil, err
+	}
+	if stored.Sealed == "" {
+		return DecodeDocument(str)
+	}
+	if box == nil {
+		return nil, ErrLocked
+	}
+	plain, err := box.OpenString(stored.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := DecodeDocument(plain)
+	if err != nil {
+		return nil, err
+	}
+	doc.Deleted, doc.Pinned = stored.Deleted, stored.Pinned
+	return doc, nil
+}
+
+// sealedTags returns tag usage counted from unsealed documents, matching Tags.
+func (d *Documents) sealedTags() ([]TagUsage, error) {
+	docs, err := d.Documents()
+	if err != nil {
+		return nil, err
+	}
+	usages := map[string]*TagUsage{}
+	for _, doc := range docs {
+		seen := map[string]bool{}
+		for _, tag := range doc.Content.Meta.Tags {
+			usage, ok := usages[tag]
+			if !ok {
+				usage = &TagUsage{Tag: tag, First: doc.Content.Created, Last: doc.Content.Modified}
+				usages[tag] = usage
+			}
+			if !seen[tag] {
+				usage.Count++
+				seen[tag] = true
+			}
+			if doc.Content.Created.Before(usage.First) {
+				usage.First = doc.Content.Created
+			}
+			if doc.Content.Modified.After(usage.Last) {
+				usage.Last = doc.Content.Modified
+			}
+		}
+	}
+	out := make([]TagUsage, 0, len(usages))
+	for _, usage := range usages {
+		out = append(out, *usage)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Tag < out[j].Tag
+	})
+	return out, nil
+}
+
+// sealedDocumentsForTag returns unsealed documents with a tag starting with
+// the given tag, matching DocumentsForTag.
+func (d *Documents) sealedDocumentsForTag(tag string) ([]Document, error) {
+	docs, err := d.newest()
+	if err != nil {
+		return nil, err
+	}
+	var out []Document
+	for _, doc := range docs {
+		for _, t := range doc.Content.Meta.Tags {
+			if strings.HasPrefix(strings.ToLower(t), strings.ToLower(tag)) {
+				out = append(out, doc)
+				break
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return out, nil
+}
+
+// sealedReferences returns the links from a document resolved against the
+// unsealed documents, matching References.
+func (d *Documents) sealedReferences(id string) ([]Reference, error) {
+	source, err := d.DocumentForIdentifier(id)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := d.newest()
+	if err != nil {
+		return nil, err
+	}
+	refs := []Reference{}
+	for i, target := range wikilink.Parse(source.Content.Text) {
+		ref := Reference{Position: int64(i), Target: target}
+		for _, doc := range docs {
+			if doc.Identifier == target {
+				ref.Identifier = doc.Identifier
+				break
+			}
+		}
+		for _, doc := range docs {
+			if ref.Identifier != "" {
+				break
+			}
+			if strings.EqualFold(wikilink.Title(doc.Content.Text), target) {
+				ref.Identifier = doc.Identifier
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// sealedDocumentsReferencing returns the unsealed documents linking to the
+// given document, matching DocumentsReferencing.
+func (d *Documents) sealedDocumentsReferencing(id string) ([]Document, error) {
+	target, err := d.DocumentForIdentifier(id)
+	if err != nil {
+		return nil, err
+	}
+	title := wikilink.Title(target.Content.Text)
+	docs, err := d.newest()
+	if err != nil {
+		return nil, err
+	}
+	out := []Document{}
+	for _, doc := range docs {
+		if doc.Identifier == id {
+			continue
+		}
+		for _, link := range wikilink.Parse(doc.Content.Text) {
+			if link == id || strings.EqualFold(link, title) {
+				out = append(out, doc)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// newest returns every document outside the trash, newest first.
+func (d *Documents) newest() ([]Document, error) {
+	var strs []string
+	if err := d.db.Select(&strs, `SELECT document FROM document WHERE deleted IS NULL ORDER BY created DESC`); err != nil {
+		return nil, err
+	}
+	return d.decodeAll(strs)
+}
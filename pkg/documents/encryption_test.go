@@ -0,0 +1,92 @@
+package documents
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/nathanborror/logger/pkg/seal"
+)
+
+func TestEncryption(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data.db")
+	db, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DocumentSave("1", Content{Text: "Secret plans", Meta: Meta{Tags: []string{"hidden"}}})
+	db.DocumentSave("1", Content{Text: "Secret plans for monday", Meta: Meta{Tags: []string{"hidden"}}})
+	db.DocumentSave("2", Content{Text: "see [[secret plans for MONDAY]]", Meta: Meta{Tags: []string{"hidden"}}})
+	db.DocumentPin("2")
+
+	if err := db.SetPassphrase("", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, word := range []string{"Secret", "monday", "MONDAY", "hidden"} {
+		if bytes.Contains(data, []byte(word)) {
+			t.Errorf("unexpected plain text in database file (%q)", word)
+		}
+	}
+
+	// Everything works while unlocked.
+	doc, err := db.DocumentForIdentifier("1")
+	if err != nil || doc.Content.Text != "Secret plans for monday" || len(doc.History) != 1 {
+		t.Errorf("unexpected document (%+v, %v)", doc, err)
+	}
+	if docs, _ := db.DocumentsPinned(); len(docs) != 1 || docs[0].Identifier != "2" {
+		t.Errorf("unexpected pinned documents (%+v)", docs)
+	}
+	if tags, _ := db.Tags(); len(tags) != 1 || tags[0].Tag != "hidden" || tags[0].Count != 2 {
+		t.Errorf("unexpected tags (%+v)", tags)
+	}
+	if docs, _ := db.DocumentsForTag("hid"); len(docs) != 2 {
+		t.Errorf("unexpected tagged documents (%+v)", docs)
+	}
+	if refs, _ := db.References("2"); len(refs) != 1 || refs[0].Identifier != "1" {
+		t.Errorf("unexpected references (%+v)", refs)
+	}
+	if docs, _ := db.DocumentsReferencing("1"); len(docs) != 1 || docs[0].Identifier != "2" {
+		t.Errorf("unexpected backlinks (%+v)", docs)
+	}
+
+	// Nothing can be read or written while locked.
+	db.Lock()
+	if _, err := db.Documents(); err != ErrLocked {
+		t.Errorf("expected locked error, got %v", err)
+	}
+	if err := db.DocumentSave("3", Content{Text: "new"}); err != ErrLocked {
+		t.Errorf("expected locked error, got %v", err)
+	}
+	if err := db.Unlock("wrong"); err != seal.ErrPassphrase {
+		t.Errorf("expected passphrase error, got %v", err)
+	}
+
+	// A reopened database starts locked.
+	db.Close()
+	if db, err = New(name); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Locked() {
+		t.Fatal("expected reopened database to be locked")
+	}
+	if err := db.Unlock("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPassphrase("hunter2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if db.Encrypted() {
+		t.Error("expected database to be decrypted")
+	}
+	if refs, _ := db.References("2"); len(refs) != 1 || refs[0].Identifier != "1" {
+		t.Errorf("unexpected references after decrypting (%+v)", refs)
+	}
+	if docs, _ := db.DocumentsForTag("hidden"); len(docs) != 2 {
+		t.Errorf("unexpected tagged documents after decrypting (%+v)", docs)
+	}
+}
@@ -0,0 +1,78 @@
+package documents
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/nathanborror/logger/pkg/migrate"
+)
+
+// migrations upgrade the schema in order, append new ones to the end and
+// never modify a migration once it has shipped.
+var migrations = []migrate.Migration{
+	// 1: Initial schema. Databases created before versioning already have
+	// these tables so everything must be conditional.
+	migrate.Exec(`
+		CREATE TABLE IF NOT EXISTS document (
+			document    TEXT NOT NULL,
+			identifier  TEXT GENERATED ALWAYS AS (json_extract(document, '$.identifier')) VIRTUAL NOT NULL UNIQUE,
+			contentType TEXT GENERATED ALWAYS AS (json_extract(document, '$.content.meta.contentType')) VIRTUAL NOT NULL,
+			tags        TEXT GENERATED ALWAYS AS (json_extract(document, '$.content.meta.tags')) VIRTUAL,
+			created     DATETIME GENERATED ALWAYS AS (json_extract(document, '$.content.created')) VIRTUAL NOT NULL,
+			modified    DATETIME GENERATED ALWAYS AS (json_extract(document, '$.content.modified')) VIRTUAL NOT NULL
+		);
+
+		CREATE VIRTUAL TABLE IF NOT EXISTS search_document_tags USING fts5(tags);
+		CREATE TRIGGER IF NOT EXISTS after_document_insert AFTER INSERT ON document BEGIN
+			INSERT INTO search_document_tags (rowid, tags) VALUES (new.rowid, new.tags);
+		END;
+		CREATE TRIGGER IF NOT EXISTS after_document_delete AFTER DELETE ON document BEGIN
+			DELETE FROM search_document_tags WHERE rowid = old.rowid;
+		END;
+	`),
+
+	// 2: Index documents in the order they're listed and paged through.
+	migrate.Exec(`
+		CREATE INDEX document_created ON document (created, identifier);
+	`),
+
+	// 3: Trash and settings.
+	migrate.Exec(`
+		ALTER TABLE document ADD COLUMN deleted DATETIME GENERATED ALWAYS AS (json_extract(document, '$.deleted')) VIRTUAL;
+		CREATE TABLE setting (
+			key   TEXT PRIMARY KEY NOT NULL,
+			value TEXT NOT NULL
+		);
+	`),
+
+	// 4: Pinned documents.
+	migrate.Exec(`
+		ALTER TABLE document ADD COLUMN pinned DATETIME GENERATED ALWAYS AS (json_extract(document, '$.pinned')) VIRTUAL;
+	`),
+
+	// 5: Links between documents.
+	func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE document_reference (
+				source   TEXT NOT NULL,
+				position INTEGER NOT NULL,
+				target   TEXT NOT NULL,
+				PRIMARY KEY (source, position)
+			);
+			CREATE INDEX document_reference_target ON document_reference (target COLLATE NOCASE);
+		`); err != nil {
+			return err
+		}
+		var rows []struct {
+			Identifier string `db:"identifier"`
+			Text       string `db:"text"`
+		}
+		if err := tx.Select(&rows, `SELECT identifier, json_extract(document, '$.content.text') AS text FROM document`); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := indexReferences(tx, row.Identifier, row.Text); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
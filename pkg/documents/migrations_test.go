@@ -0,0 +1,46 @@
+package documents
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nathanborror/logger/pkg/migrate"
+)
+
+func TestMigrations(t *testing.T) {
+	query, err := ioutil.ReadFile(filepath.Join("testdata", "v0.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "data.logger")
+	conn, err := sqlx.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.MustExec(string(query))
+	conn.Close()
+
+	db, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := migrate.Version(db.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("version != %d (%d)", len(migrations), version)
+	}
+	docs, err := db.Documents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[0].Identifier != "1614729600" {
+		t.Errorf("unexpected documents (%+v)", docs)
+	}
+	if docs, _ := db.DocumentsForTag("errands"); len(docs) != 1 {
+		t.Errorf("documents tagged errands != 1 (%d)", len(docs))
+	}
+}
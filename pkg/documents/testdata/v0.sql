@@ -0,0 +1,21 @@
+-- Schema created by releases before versioned migrations.
+CREATE TABLE IF NOT EXISTS document (
+	document    TEXT NOT NULL,
+	identifier  TEXT GENERATED ALWAYS AS (json_extract(document, '$.identifier')) VIRTUAL NOT NULL UNIQUE,
+	contentType TEXT GENERATED ALWAYS AS (json_extract(document, '$.content.meta.contentType')) VIRTUAL NOT NULL,
+	tags        TEXT GENERATED ALWAYS AS (json_extract(document, '$.content.meta.tags')) VIRTUAL,
+	created     DATETIME GENERATED ALWAYS AS (json_extract(document, '$.content.created')) VIRTUAL NOT NULL,
+	modified    DATETIME GENERATED ALWAYS AS (json_extract(document, '$.content.modified')) VIRTUAL NOT NULL
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS search_document_tags USING fts5(tags);
+PRAGMA RECURSIVE_TRIGGERS = true;
+CREATE TRIGGER IF NOT EXISTS after_document_insert AFTER INSERT ON document BEGIN
+	INSERT INTO search_document_tags (rowid, tags) VALUES (new.rowid, new.tags);
+END;
+CREATE TRIGGER IF NOT EXISTS after_document_delete AFTER DELETE ON document BEGIN
+	DELETE FROM search_document_tags WHERE rowid = old.rowid;
+END;
+
+INSERT INTO document (document) VALUES ('{"identifier":"1614556800","content":{"text":"buy milk","created":"2021-03-01T00:00:00Z","modified":"2021-03-01T00:00:00Z","meta":{"contentType":"","tags":["errands"],"color":1}},"history":[]}');
+INSERT INTO document (document) VALUES ('{"identifier":"1614729600","content":{"text":"fix the build","created":"2021-03-03T00:00:00Z","modified":"2021-03-03T00:00:00Z","meta":{"contentType":"","tags":["work"],"color":2}},"history":[]}');
@@ -7,9 +7,11 @@ import (
 
 // Document represents a complete database record.
 type Document struct {
-	Identifier string    `json:"identifier"`
-	Content    Content   `json:"content"`
-	History    []Content `json:"history"`
+	Identifier string     `json:"identifier"`
+	Content    Content    `json:"content"`
+	History    []Content  `json:"history"`
+	Deleted    *time.Time `json:"deleted,omitempty"`
+	Pinned     *time.Time `json:"pinned,omitempty"`
 }
 
 // Content represents the content portion of the Document.
@@ -41,6 +43,22 @@ type Coordinate struct {
 	Altitude  float64 `json:"altitude"`
 }
 
+// TagUsage describes how many documents use a tag and when it was used.
+type TagUsage struct {
+	Tag   string    `json:"tag"`
+	Count int64     `json:"count"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// Reference is a [[link]] from one document to another by identifier or
+// title. Identifier is empty when the link doesn't match any document.
+type Reference struct {
+	Position   int64  `json:"position" db:"position"`
+	Target     string `json:"target" db:"target"`
+	Identifier string `json:"identifier" db:"identifier"`
+}
+
 // NewDocument returns a new empty document.
 func NewDocument() Document {
 	now := time.Now()
@@ -0,0 +1,300 @@
+// Package export writes the entries of a Stater as JSON, CSV or Markdown.
+// Entries are read a page at a time and written as they're read so exporting
+// a large journal doesn't require holding it in memory.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nathanborror/logger/pkg/state"
+)
+
+// Export formats.
+const (
+	JSON     = "json"     // a single array of entries
+	CSV      = "csv"      // one row per entry with a header row
+	Markdown = "markdown" // entries grouped under a heading per day
+)
+
+// pageLimit is the number of entries read from the Stater at a time.
+const pageLimit = 100
+
+// Entry is an exported entry. Text is as it was written, hashtags included,
+// so it can be imported again. Tags are listed without the leading #.
+type Entry struct {
+	ID       string    `json:"id"`
+	Text     string    `json:"text"`
+	Color    int64     `json:"color"`
+	Tags     []string  `json:"tags"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+
+	pinned bool
+}
+
+// Export writes every current entry to w in the given format, newest first.
+// When query isn't empty only entries matching it are written. It returns
+// the number of entries written.
+func Export(s state.Stater, w io.Writer, format string, query string) (int, error) {
+	enc, err := newEncoder(w, format)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		cursor string
+		count  int
+		pinned []Entry // held back until their position in time
+	)
+	emit := func(e Entry) error {
+		count++
+		return enc.Encode(e)
+	}
+	for {
+		var data []byte
+		if query == "" {
+			data = s.CurrentPage(cursor, pageLimit)
+		} else {
+			data = s.EntrySearchPage(query, cursor, pageLimit)
+		}
+		entries, next, err := decodePage(data)
+		if err != nil {
+			return count, err
+		}
+		for _, e := range entries {
+			if e.pinned {
+				pinned = append(pinned, e)
+			}
+		}
+		sortNewestFirst(pinned)
+		for _, e := range entries {
+			if e.pinned {
+				continue
+			}
+			for len(pinned) > 0 && pinned[0].Created.After(e.Created) {
+				if err := emit(pinned[0]); err != nil {
+					return count, err
+				}
+				pinned = pinned[1:]
+			}
+			if err := emit(e); err != nil {
+				return count, err
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	for _, e := range pinned {
+		if err := emit(e); err != nil {
+			return count, err
+		}
+	}
+	return count, enc.Close()
+}
+
+func sortNewestFirst(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Created.After(entries[j].Created) })
+}
+
+// page decodes the snapshots of both the production and beta backends.
+type page struct {
+	Entries []struct {
+		ID       int64  `json:"id"`
+		Text     string `json:"text"`
+		Source   string `json:"source"`
+		Color    int64  `json:"color"`
+		Created  int64  `json:"created"`
+		Modified int64  `json:"modified"`
+		Pinned   bool   `json:"pinned"`
+		Tags     []struct {
+			ID string `json:"id"`
+		} `json:"tags"`
+	} `json:"entries"`
+	Documents []struct {
+		Identifier string     `json:"identifier"`
+		Pinned     *time.Time `json:"pinned"`
+		Content    struct {
+			Text     string    `json:"text"`
+			Created  time.Time `json:"created"`
+			Modified time.Time `json:"modified"`
+			Meta     struct {
+				Tags  []string `json:"tags"`
+				Color int64    `json:"color"`
+			} `json:"meta"`
+		} `json:"content"`
+	} `json:"documents"`
+	Cursor string `json:"cursor"`
+	Error  *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func decodePage(data []byte) ([]Entry, string, error) {
+	var p page
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, "", err
+	}
+	if p.Error != nil {
+		return nil, "", fmt.Errorf("%s: %s", p.Error.Code, p.Error.Message)
+	}
+	var entries []Entry
+	for _, e := range p.Entries {
+		tags := []string{}
+		for _, tag := range e.Tags {
+			tags = append(tags, tag.ID)
+		}
+		entries = append(entries, Entry{
+			ID:       strconv.FormatInt(e.ID, 10),
+			Text:     e.Source,
+			Color:    e.Color,
+			Tags:     tags,
+			Created:  time.Unix(e.Created, 0),
+			Modified: time.Unix(e.Modified, 0),
+			pinned:   e.Pinned,
+		})
+	}
+	for _, d := range p.Documents {
+		tags := d.Content.Meta.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		entries = append(entries, Entry{
+			ID:       d.Identifier,
+			Text:     d.Content.Text,
+			Color:    d.Content.Meta.Color,
+			Tags:     tags,
+			Created:  d.Content.Created,
+			Modified: d.Content.Modified,
+			pinned:   d.Pinned != nil,
+		})
+	}
+	return entries, p.Cursor, nil
+}
+
+// encoder writes entries in a format.
+type encoder interface {
+	Encode(e Entry) error
+	Close() error
+}
+
+func newEncoder(w io.Writer, format string) (encoder, error) {
+	switch format {
+	case JSON:
+		return &jsonEncoder{w: w}, nil
+	case CSV:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	case Markdown:
+		return &markdownEncoder{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown export format '%s'", format)
+}
+
+type jsonEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonEncoder) Encode(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if e.count == 0 {
+		sep = "[\n"
+	}
+	e.count++
+	_, err = io.WriteString(e.w, sep+string(data))
+	return err
+}
+
+func (e *jsonEncoder) Close() error {
+	end := "\n]\n"
+	if e.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) Encode(entry Entry) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	if err := e.w.Write([]string{
+		entry.ID,
+		entry.Created.Format(time.RFC3339),
+		entry.Modified.Format(time.RFC3339),
+		strconv.FormatInt(entry.Color, 10),
+		strings.Join(entry.Tags, " "),
+		entry.Text,
+	}); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.w.Write([]string{"id", "created", "modified", "color", "tags", "text"})
+}
+
+type markdownEncoder struct {
+	w   io.Writer
+	day string
+}
+
+func (e *markdownEncoder) Encode(entry Entry) error {
+	var b strings.Builder
+	if day := entry.Created.Format("2006-01-02"); day != e.day {
+		e.day = day
+		fmt.Fprintf(&b, "## %s\n\n", entry.Created.Format("Monday, January 2, 2006"))
+	}
+	fmt.Fprintf(&b, "### %s\n\n%s\n", entry.Created.Format("15:04"), strings.TrimSpace(entry.Text))
+	if len(entry.Tags) > 0 && !hasAllTags(entry.Text, entry.Tags) {
+		b.WriteString("\n#" + strings.Join(entry.Tags, " #") + "\n")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *markdownEncoder) Close() error {
+	return nil
+}
+
+// hasAllTags reports whether every tag already appears as a hashtag in text.
+func hasAllTags(text string, tags []string) bool {
+	for _, tag := range tags {
+		if !strings.Contains(text, "#"+tag) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nathanborror/logger/pkg/state/production"
+)
+
+func TestExport(t *testing.T) {
+	db := production.New(":memory:")
+	for i := 0; i < 150; i++ {
+		db.EntryCreate("note #daily", 0)
+	}
+	db.EntryCreate("buy milk #errands", 1)
+	db.EntryPin(1)
+
+	var buf bytes.Buffer
+	n, err := Export(db, &buf, JSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if n != 151 || len(entries) != 151 {
+		t.Fatalf("expected 151 entries (%d, %d)", n, len(entries))
+	}
+	if entries[0].Text != "buy milk #errands" || entries[0].Tags[0] != "errands" || entries[0].Color != 1 {
+		t.Errorf("unexpected first entry (%+v)", entries[0])
+	}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if seen[e.ID] {
+			t.Fatalf("duplicate entry %s", e.ID)
+		}
+		seen[e.ID] = true
+	}
+
+	buf.Reset()
+	if n, err = Export(db, &buf, CSV, "milk"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(rows) != 2 || rows[1][0] != "151" || rows[1][4] != "errands" {
+		t.Errorf("unexpected rows (%v)", rows)
+	}
+
+	buf.Reset()
+	if _, err = Export(db, &buf, Markdown, "milk OR note"); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); strings.Count(out, "\n## ") != 0 || !strings.HasPrefix(out, "## ") || !strings.Contains(out, "\nbuy milk #errands\n\n") {
+		t.Errorf("unexpected markdown (%q)", out[:200])
+	}
+
+	if _, err := Export(db, &buf, "xml", ""); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	if _, err := Export(db, &buf, JSON, `"unterminated`); err == nil {
+		t.Errorf("expected error for invalid query")
+	}
+}
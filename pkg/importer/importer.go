@@ -0,0 +1,325 @@
+// Package importer reads entries from other tools into a Stater. It reads
+// the JSON written by the export package, CSV with text, created, color and
+// tags columns, and plain text split into entries by a delimiter. Original
+// timestamps are kept and entries already present are skipped.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nathanborror/logger/pkg/state"
+)
+
+// Import formats.
+const (
+	JSON = "json"
+	CSV  = "csv"
+	Text = "text"
+)
+
+// DefaultDelimiter separates entries in plain text when no delimiter is given.
+const DefaultDelimiter = "\n---\n"
+
+// batchSize is the number of records sent to the Stater at a time.
+const batchSize = 500
+
+// timeLayouts are the accepted formats of timestamps in CSV and plain text.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Options configure an import.
+type Options struct {
+	// Delimiter separates entries in plain text, DefaultDelimiter when empty.
+	Delimiter string
+	// Created is used for plain text entries whose first line isn't a
+	// timestamp, such as the modification time of the file. Entries without
+	// a timestamp fail when it's zero.
+	Created time.Time
+}
+
+// Summary counts the records imported, skipped as duplicates and failed.
+type Summary struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors"`
+}
+
+// record matches the records accepted by Stater.EntriesImport.
+type record struct {
+	Ref      string `json:"ref"`
+	Text     string `json:"text"`
+	Color    int64  `json:"color"`
+	Created  int64  `json:"created"`
+	Modified int64  `json:"modified"`
+}
+
+// Import reads entries from r in the given format into s. Records that can't
+// be read are counted as failed and the import carries on.
+func Import(s state.Stater, r io.Reader, format string, opts Options) (*Summary, error) {
+	imp := &importer{stater: s, summary: &Summary{Errors: []string{}}}
+	var err error
+	switch format {
+	case JSON:
+		err = imp.readJSON(r)
+	case CSV:
+		err = imp.readCSV(r)
+	case Text:
+		err = imp.readText(r, opts)
+	default:
+		return nil, fmt.Errorf("unknown import format '%s'", format)
+	}
+	if err == nil {
+		err = imp.flush()
+	}
+	return imp.summary, err
+}
+
+type importer struct {
+	stater  state.Stater
+	batch   []record
+	summary *Summary
+}
+
+func (imp *importer) add(r record) error {
+	imp.batch = append(imp.batch, r)
+	if len(imp.batch) < batchSize {
+		return nil
+	}
+	return imp.flush()
+}
+
+func (imp *importer) fail(ref string, err error) {
+	imp.summary.Failed++
+	imp.summary.Errors = append(imp.summary.Errors, ref+": "+err.Error())
+}
+
+func (imp *importer) flush() error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(imp.batch)
+	if err != nil {
+		return err
+	}
+	imp.batch = imp.batch[:0]
+	var res struct {
+		Summary
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(imp.stater.EntriesImport(data), &res); err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return fmt.Errorf("%s: %s", res.Error.Code, res.Error.Message)
+	}
+	imp.summary.Imported += res.Imported
+	imp.summary.Skipped += res.Skipped
+	imp.summary.Failed += res.Failed
+	imp.summary.Errors = append(imp.summary.Errors, res.Errors...)
+	return nil
+}
+
+// readJSON reads an array of entries as written by the export package.
+func (imp *importer) readJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('[') {
+		return fmt.Errorf("expected an array of entries")
+	}
+	for n := 1; dec.More(); n++ {
+		ref := fmt.Sprintf("entry %d", n)
+		var e struct {
+			Text     string    `json:"text"`
+			Color    int64     `json:"color"`
+			Tags     []string  `json:"tags"`
+			Created  time.Time `json:"created"`
+			Modified time.Time `json:"modified"`
+		}
+		if err := dec.Decode(&e); err != nil {
+			if _, ok := err.(*json.UnmarshalTypeError); !ok {
+				return err
+			}
+			imp.fail(ref, err)
+			continue
+		}
+		if err := imp.add(record{
+			Ref:      ref,
+			Text:     withTags(e.Text, e.Tags),
+			Color:    e.Color,
+			Created:  e.Created.Unix(),
+			Modified: e.Modified.Unix(),
+		}); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// readCSV reads rows with a header naming the text, created, color, tags and
+// optionally modified columns. Tags are separated by spaces or commas.
+func (imp *importer) readCSV(r io.Reader) error {
+	rd := csv.NewReader(r)
+	rd.FieldsPerRecord = -1
+	header, err := rd.Read()
+	if err != nil {
+		return err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["text"]; !ok {
+		return fmt.Errorf("missing text column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	for n := 2; ; n++ {
+		row, err := rd.Read()
+		if err == io.EOF {
+			return nil
+		}
+		ref := fmt.Sprintf("row %d", n)
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return err
+			}
+			imp.fail(ref, err)
+			continue
+		}
+		// Rows may be shorter than the header.
+		if columns["text"] >= len(row) {
+			imp.fail(ref, fmt.Errorf("missing text"))
+			continue
+		}
+		created, err := parseTime(field(row, "created"))
+		if err != nil {
+			imp.fail(ref, err)
+			continue
+		}
+		modified := created
+		if str := field(row, "modified"); str != "" {
+			if modified, err = parseTime(str); err != nil {
+				imp.fail(ref, err)
+				continue
+			}
+		}
+		var color int64
+		if str := field(row, "color"); str != "" {
+			if color, err = strconv.ParseInt(str, 10, 64); err != nil {
+				imp.fail(ref, fmt.Errorf("invalid color '%s'", str))
+				continue
+			}
+		}
+		tags := strings.FieldsFunc(field(row, "tags"), func(r rune) bool { return r == ',' || r == ' ' })
+		if err := imp.add(record{
+			Ref:      ref,
+			Text:     withTags(row[columns["text"]], tags),
+			Color:    color,
+			Created:  created.Unix(),
+			Modified: modified.Unix(),
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// readText reads entries separated by the delimiter. An entry whose first
+// line is a timestamp was created at that time.
+func (imp *importer) readText(r io.Reader, opts Options) error {
+	delim := opts.Delimiter
+	if delim == "" {
+		delim = DefaultDelimiter
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	scanner.Split(splitOn([]byte(delim)))
+	for n := 1; scanner.Scan(); n++ {
+		ref := fmt.Sprintf("entry %d", n)
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		created := opts.Created
+		lines := strings.SplitN(text, "\n", 2)
+		if t, err := parseTime(strings.TrimSpace(lines[0])); err == nil && len(lines) == 2 {
+			created, text = t, strings.TrimSpace(lines[1])
+		}
+		if created.IsZero() {
+			imp.fail(ref, fmt.Errorf("missing created time"))
+			continue
+		}
+		if err := imp.add(record{Ref: ref, Text: text, Created: created.Unix(), Modified: created.Unix()}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// splitOn returns a split function for bufio.Scanner separating tokens by
+// the given delimiter.
+func splitOn(delim []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.Index(data, delim); i >= 0 {
+			return i + len(delim), data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+func parseTime(str string) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, fmt.Errorf("missing created time")
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s'", str)
+}
+
+// withTags appends the tags missing from text as hashtags. Older exports
+// have their hashtags removed from the text and listed as tags instead.
+func withTags(text string, tags []string) string {
+	var missing []string
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(tag, "#")
+		if tag != "" && !strings.Contains(text, "#"+tag) {
+			missing = append(missing, "#"+tag)
+		}
+	}
+	if len(missing) == 0 {
+		return text
+	}
+	return strings.TrimSpace(text) + " " + strings.Join(missing, " ")
+}
@@ -0,0 +1,181 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathanborror/logger/pkg/export"
+	"github.com/nathanborror/logger/pkg/state/production"
+)
+
+type snapshot struct {
+	Entries []struct {
+		ID      int64  `json:"id"`
+		Text    string `json:"text"`
+		Color   int64  `json:"color"`
+		Created int64  `json:"created"`
+		Tags    []struct {
+			ID string `json:"id"`
+		} `json:"tags"`
+	} `json:"entries"`
+}
+
+func current(t *testing.T, data []byte) snapshot {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestImportCSV(t *testing.T) {
+	db := production.New(":memory:")
+	in := "text,created,color,tags\n" +
+		"buy milk,2021-03-01 09:30:00,2,errands home\n" +
+		"\"multi\nline\",1614000000,0,\n" +
+		"no date,,0,\n" +
+		"bad color,2021-03-02,blue,\n"
+	summary, err := Import(db, strings.NewReader(in), CSV, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != 2 || summary.Failed != 2 || len(summary.Errors) != 2 || !strings.HasPrefix(summary.Errors[0], "row 4") {
+		t.Errorf("unexpected summary (%+v)", summary)
+	}
+	s := current(t, db.Current())
+	milk := s.Entries[0]
+	created, _ := time.ParseInLocation("2006-01-02 15:04:05", "2021-03-01 09:30:00", time.Local)
+	if milk.Created != created.Unix() || milk.Color != 2 || len(milk.Tags) != 2 {
+		t.Errorf("unexpected entry (%+v)", milk)
+	}
+
+	summary, err = Import(db, strings.NewReader(in), CSV, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != 0 || summary.Skipped != 2 {
+		t.Errorf("expected duplicates to be skipped (%+v)", summary)
+	}
+
+	// Rows can be shorter than the header.
+	in = "created,color,text\n" +
+		"2021-03-01,1\n" +
+		"2021-03-02,1,short row\n"
+	summary, err = Import(db, strings.NewReader(in), CSV, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != 1 || summary.Failed != 1 || !strings.HasPrefix(summary.Errors[0], "row 2") {
+		t.Errorf("expected short row to fail (%+v)", summary)
+	}
+}
+
+func TestImportText(t *testing.T) {
+	db := production.New(":memory:")
+	in := "2021-03-01 09:30\nfirst note\n===\nsecond note\nwith two lines\n===\n\n"
+	fallback := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	summary, err := Import(db, strings.NewReader(in), Text, Options{Delimiter: "\n===\n", Created: fallback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != 2 {
+		t.Fatalf("unexpected summary (%+v)", summary)
+	}
+	s := current(t, db.Current())
+	if s.Entries[0].Text != "first note" || s.Entries[1].Created != fallback.Unix() {
+		t.Errorf("unexpected entries (%+v)", s.Entries)
+	}
+
+	summary, err = Import(db, strings.NewReader("no timestamp"), Text, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Failed != 1 {
+		t.Errorf("expected entry without timestamp to fail (%+v)", summary)
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	src := production.New(":memory:")
+	src.EntryCreate("buy milk #errands", 1)
+	src.EntryCreate("call mom", 0)
+	var buf bytes.Buffer
+	if _, err := export.Export(src, &buf, export.JSON, ""); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Importing an export into its own database finds every entry.
+	summary, err := Import(src, bytes.NewReader(data), JSON, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != 0 || summary.Skipped != 2 {
+		t.Errorf("unexpected summary (%+v)", summary)
+	}
+
+	dst := production.New(":memory:")
+	if summary, err = Import(dst, bytes.NewReader(data), JSON, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != 2 {
+		t.Errorf("unexpected summary (%+v)", summary)
+	}
+	imported := map[string]int64{}
+	for _, e := range current(t, dst.Current()).Entries {
+		imported[e.Text] = e.Created
+		if e.Text == "buy milk" && (e.Color != 1 || len(e.Tags) != 1) {
+			t.Errorf("unexpected imported entry (%+v)", e)
+		}
+	}
+	for _, e := range current(t, src.Current()).Entries {
+		if created, ok := imported[e.Text]; !ok || created != e.Created {
+			t.Errorf("entry %d not imported (%+v)", e.ID, e)
+		}
+	}
+
+	if _, err := Import(dst, strings.NewReader(`{"not": "an array"}`), JSON, Options{}); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestImportExportRoundTrip(t *testing.T) {
+	texts := []string{
+		"Packing list #travel\n\n- [ ] socks\n- [x] charger\n\nsee notes",
+		"First paragraph.\n\nSecond  paragraph #work:review with #due=2021-04-01",
+	}
+	for _, format := range []string{export.JSON, export.CSV} {
+		src := production.New(":memory:")
+		for _, text := range texts {
+			src.EntryCreate(text, 0)
+		}
+		var buf bytes.Buffer
+		if _, err := export.Export(src, &buf, format, ""); err != nil {
+			t.Fatal(err)
+		}
+		dst := production.New(":memory:")
+		if summary, err := Import(dst, &buf, format, Options{}); err != nil || summary.Imported != len(texts) {
+			t.Fatalf("%s: unexpected import (%+v, %v)", format, summary, err)
+		}
+		buf.Reset()
+		if _, err := export.Export(dst, &buf, export.JSON, ""); err != nil {
+			t.Fatal(err)
+		}
+		var entries []export.Entry
+		if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]bool{}
+		for _, e := range entries {
+			got[e.Text] = true
+		}
+		for _, text := range texts {
+			if !got[text] {
+				t.Errorf("%s: text changed by round trip (%q)", format, text)
+			}
+		}
+	}
+}
@@ -1,16 +1,41 @@
 package logger
 
 import (
+	"encoding/json"
+	"os"
+
+	"github.com/nathanborror/logger/pkg/export"
+	"github.com/nathanborror/logger/pkg/importer"
 	"github.com/nathanborror/logger/pkg/state"
 	"github.com/nathanborror/logger/pkg/state/beta"
 	"github.com/nathanborror/logger/pkg/state/production"
+	"github.com/nathanborror/logger/pkg/syncer"
 )
 
 const version = "1.0"
 
+// Search rankings, see the state package for details.
+const (
+	RankChronological = state.RankChronological
+	RankRelevance     = state.RankRelevance
+	RankRecency       = state.RankRecency
+)
+
 // Stater is a alias to the state package which is otherwise invisible to the ios framework.
 type Stater state.Stater
 
+// Observer is notified of changes to entries, see state.Observer. It's
+// declared here so it's visible to the ios framework.
+type Observer interface {
+	EntriesChanged(data []byte)
+}
+
+// Observe registers o to be notified of changes to the entries of s and
+// returns an id to pass to Stater.Unobserve.
+func Observe(s Stater, o Observer) int64 {
+	return s.Observe(o)
+}
+
 // New returns and implementation of the state interface.
 func New(kind, name string) Stater {
 	state.Register("production", production.New)
@@ -18,6 +43,67 @@ func New(kind, name string) Stater {
 	return state.NewStater(kind, name)
 }
 
+// ConvertToBeta copies the entries of the production database at src into the
+// beta database at dst. It's safe to run repeatedly and returns a JSON summary.
+func ConvertToBeta(src, dst string) []byte {
+	return production.Convert(src, dst)
+}
+
+// Export writes the entries matching query, or every entry when query is
+// empty, to the file at path as json, csv or markdown. It returns the number
+// of entries written.
+func Export(s Stater, path, format, query string) (int, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := export.Export(s, f, format, query)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// Import reads the entries in the file at path, formatted as json, csv or
+// text, into s and returns a JSON summary. Plain text entries are separated
+// by delimiter, or lines of --- when it's empty, and entries without a
+// timestamp on their first line take the file's modification time.
+func Import(s Stater, path, format, delimiter string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	summary, err := importer.Import(s, f, format, importer.Options{Delimiter: delimiter, Created: info.ModTime()})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(summary)
+}
+
+// Sync exchanges changes between s and the Logger serving at url, which
+// must accept token, and returns a JSON summary.
+func Sync(s Stater, url, token string) ([]byte, error) {
+	summary, err := syncer.Sync(s, url, syncer.Options{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(summary)
+}
+
+// Serve serves the changes of s to peers syncing with it at addr until it
+// fails. Peers must send token, which can't be empty. Changes include the
+// plain text of every entry so they're served over TLS with the certificate
+// and key in certFile and keyFile, which may only be left empty when addr is
+// a loopback address.
+func Serve(s Stater, addr, token, certFile, keyFile string) error {
+	return syncer.ListenAndServe(s, addr, token, certFile, keyFile)
+}
+
 // Version returns the current version of the framework.
 func Version() string {
 	return version
@@ -0,0 +1,63 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration upgrades a database schema by one version.
+type Migration func(tx *sqlx.Tx) error
+
+// Version returns the schema version recorded in the database.
+func Version(db *sqlx.DB) (int, error) {
+	var version int
+	err := db.Get(&version, `PRAGMA user_version`)
+	return version, err
+}
+
+// Run applies the migrations a database hasn't seen yet in order. The schema
+// version is kept in PRAGMA user_version where version N means the first N
+// migrations have been applied. Each migration runs in its own transaction
+// along with the version bump so an interrupted upgrade resumes where it
+// left off.
+func Run(db *sqlx.DB, migrations []Migration) error {
+	version, err := Version(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database version %d is newer than supported version %d", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		if err := apply(db, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("failed to migrate to version %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func apply(db *sqlx.DB, version int, migration Migration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := migration(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// PRAGMA statements can't be parameterized.
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Exec returns a migration that executes the given SQL statements.
+func Exec(query string) Migration {
+	return func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
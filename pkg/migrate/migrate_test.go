@@ -0,0 +1,70 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3" // driver
+)
+
+func open(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestRun(t *testing.T) {
+	db := open(t)
+	migrations := []Migration{
+		func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE foo (id integer)`)
+			return err
+		},
+		func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE foo ADD COLUMN name text`)
+			return err
+		},
+	}
+	if err := Run(db, migrations[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Run(db, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if err := Run(db, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := Version(db); version != 2 {
+		t.Errorf("version != 2 (%d)", version)
+	}
+	if err := Run(db, migrations[:1]); err == nil {
+		t.Errorf("expected error for newer database")
+	}
+}
+
+func TestRunRollback(t *testing.T) {
+	db := open(t)
+	migrations := []Migration{
+		func(tx *sqlx.Tx) error {
+			if _, err := tx.Exec(`CREATE TABLE foo (id integer)`); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		},
+	}
+	if err := Run(db, migrations); err == nil {
+		t.Fatal("expected error")
+	}
+	if version, _ := Version(db); version != 0 {
+		t.Errorf("version != 0 (%d)", version)
+	}
+	var count int
+	db.Get(&count, `SELECT count(*) FROM sqlite_master WHERE name = 'foo'`)
+	if count != 0 {
+		t.Errorf("migration was not rolled back")
+	}
+}
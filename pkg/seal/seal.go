@@ -0,0 +1,161 @@
+// Package seal encrypts data at rest. Data is sealed with AES-256-GCM under a
+// random data key, which is in turn sealed under a key derived from the
+// user's passphrase with PBKDF2-HMAC-SHA256. Every keyring has its own data
+// key, so changing the passphrase reseals the data under a new key.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// DefaultIterations is the PBKDF2 iteration count for new keyrings.
+const DefaultIterations = 200000
+
+// prefix marks sealed strings so they're never mistaken for plain text.
+const prefix = "sealed:v1:"
+
+// ErrPassphrase is returned when unlocking a keyring with the wrong passphrase.
+var ErrPassphrase = errors.New("incorrect passphrase")
+
+// ErrMalformed is returned when opening data that wasn't sealed or has been
+// tampered with.
+var ErrMalformed = errors.New("malformed sealed data")
+
+// Box seals and opens data with a data key.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// Keyring is a data key sealed with a passphrase, safe to store alongside the
+// data it protects.
+type Keyring struct {
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	Key        []byte `json:"key"` // the sealed data key
+}
+
+// NewKeyring returns a keyring for a new random data key sealed with the
+// given passphrase along with a box for the data key.
+func NewKeyring(passphrase string) (*Keyring, *Box, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	box, err := newBox(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := wrap(key, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, box, nil
+}
+
+// Unlock returns a box for the data key sealed in the keyring.
+func (k *Keyring) Unlock(passphrase string) (*Box, error) {
+	wrapper, err := newBox(deriveKey(passphrase, k.Salt, k.Iterations))
+	if err != nil {
+		return nil, err
+	}
+	key, err := wrapper.Open(k.Key)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return newBox(key)
+}
+
+func wrap(key []byte, passphrase string) (*Keyring, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	wrapper, err := newBox(deriveKey(passphrase, salt, DefaultIterations))
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{Salt: salt, Iterations: DefaultIterations, Key: wrapper.Seal(key)}, nil
+}
+
+func newBox(key []byte) (*Box, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts and authenticates data, returning the nonce followed by the
+// ciphertext.
+func (b *Box) Seal(data []byte) []byte {
+	nonce := make([]byte, b.aead.NonceSize(), b.aead.NonceSize()+len(data)+b.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // the system's random source failing is unrecoverable
+	}
+	return b.aead.Seal(nonce, nonce, data, nil)
+}
+
+// Open authenticates and decrypts data returned by Seal.
+func (b *Box) Open(sealed []byte) ([]byte, error) {
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrMalformed
+	}
+	data, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return data, nil
+}
+
+// SealString seals a string, returning printable text.
+func (b *Box) SealString(str string) string {
+	return prefix + base64.RawStdEncoding.EncodeToString(b.Seal([]byte(str)))
+}
+
+// OpenString opens a string returned by SealString.
+func (b *Box) OpenString(str string) (string, error) {
+	if !IsSealed(str) {
+		return "", ErrMalformed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(str[len(prefix):])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	data, err := b.Open(sealed)
+	return string(data), err
+}
+
+// IsSealed reports whether the string was returned by SealString.
+func IsSealed(str string) bool {
+	return strings.HasPrefix(str, prefix)
+}
+
+// deriveKey implements PBKDF2 (RFC 8018) with HMAC-SHA256 for a 32 byte key.
+func deriveKey(passphrase string, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, []byte(passphrase))
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
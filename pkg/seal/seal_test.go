@@ -0,0 +1,50 @@
+package seal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	// RFC 7914 section 11
+	key := deriveKey("password", []byte("salt"), 4096)
+	expected := "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"
+	if hex.EncodeToString(key) != expected {
+		t.Errorf("expected %s, got %x", expected, key)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	keyring, box, err := NewKeyring("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := box.SealString("buy milk")
+	if !IsSealed(sealed) || sealed == box.SealString("buy milk") {
+		t.Errorf("expected a distinct sealed string (%s)", sealed)
+	}
+
+	if _, err := keyring.Unlock("wrong"); err != ErrPassphrase {
+		t.Errorf("expected ErrPassphrase (%v)", err)
+	}
+	unlocked, err := keyring.Unlock("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str, err := unlocked.OpenString(sealed); err != nil || str != "buy milk" {
+		t.Errorf("unexpected opened string (%q, %v)", str, err)
+	}
+
+	data := box.Seal([]byte{1, 2, 3})
+	data[len(data)-1] ^= 1
+	if _, err := box.Open(data); err != ErrMalformed {
+		t.Errorf("expected tampered data to fail (%v)", err)
+	}
+	if out, err := box.Open(box.Seal([]byte{1, 2, 3})); err != nil || !bytes.Equal(out, []byte{1, 2, 3}) {
+		t.Errorf("unexpected opened data (%v, %v)", out, err)
+	}
+	if _, err := box.OpenString("plain text"); err != ErrMalformed {
+		t.Errorf("expected plain text to fail (%v)", err)
+	}
+}
@@ -1,31 +1,89 @@
 package beta
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // driver
+	"github.com/nathanborror/logger/pkg/checklist"
 	"github.com/nathanborror/logger/pkg/documents"
+	"github.com/nathanborror/logger/pkg/seal"
 	"github.com/nathanborror/logger/pkg/state"
 )
 
 type manager struct {
-	docs *documents.Documents
+	docs    *documents.Documents
+	watcher watcher
 }
 
 type snapshot struct {
 	Documents []documents.Document `json:"documents"`
+	Cursor    string               `json:"cursor"`
 	Error     *Error               `json:"error"`
 }
 
+// tagUsage is a tag along with how often and when it has been used.
+type tagUsage struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Count     int64  `json:"count"`
+	First     int64  `json:"first"`
+	Last      int64  `json:"last"`
+}
+
+// tagGroup is the list of tags sharing a namespace.
+type tagGroup struct {
+	Namespace string     `json:"namespace"`
+	Tags      []tagUsage `json:"tags"`
+}
+
+type catalog struct {
+	Namespaces []tagGroup `json:"namespaces"`
+	Error      *Error     `json:"error"`
+}
+
+// revision is a version of a document's content. Revision ids are positions
+// in the document history, counting from one, with the current content last.
+type revision struct {
+	ID      int64             `json:"id"`
+	Content documents.Content `json:"content"`
+}
+
+type revisions struct {
+	Revisions []revision `json:"revisions"`
+	Error     *Error     `json:"error"`
+}
+
+// reference is a link from an entry to another entry.
+type reference struct {
+	documents.Reference
+	Dangling bool `json:"dangling"`
+}
+
+type references struct {
+	References []reference `json:"references"`
+	Error      *Error      `json:"error"`
+}
+
+// pageLimit is the number of documents mutations return.
+const pageLimit = 50
+
 // New sets up a new database if one doesn't already exist.
 func New(name string) state.Stater {
 	docs, err := documents.New(name)
 	if err != nil {
 		panic(err)
 	}
-	return &manager{docs: docs}
+	m := &manager{docs: docs}
+	if err := m.purgeTrash(); err != nil {
+		panic(err)
+	}
+	return m
 }
 
 // Current returns the latest entries.
@@ -37,6 +95,32 @@ func (m *manager) Current() []byte {
 	return encodeDocuments(documents)
 }
 
+// CurrentPage returns up to limit of the latest entries following the given
+// cursor. An empty cursor starts at the most recent entry and a limit of zero
+// returns all remaining entries. Pinned entries are listed at the top of the
+// first page in addition to the limit.
+func (m *manager) CurrentPage(cursor string, limit int64) []byte {
+	created, identifier, err := decodeCursor(cursor)
+	if err != nil {
+		return encodeError(err)
+	}
+	var pinned []documents.Document
+	if cursor == "" {
+		if pinned, err = m.docs.DocumentsPinned(); err != nil {
+			return encodeError(err)
+		}
+	}
+	fetch := limit
+	if limit > 0 {
+		fetch = limit + 1
+	}
+	documents, err := m.docs.DocumentsBefore(created, identifier, fetch)
+	if err != nil {
+		return encodeError(err)
+	}
+	return encodePage(pinned, documents, limit)
+}
+
 // EntryCreate creates a new entry.
 func (m *manager) EntryCreate(text string, color int64) []byte {
 	id := fmt.Sprintf("%d", time.Now().Unix())
@@ -51,7 +135,7 @@ func (m *manager) EntryCreate(text string, color int64) []byte {
 	if err := m.docs.DocumentSave(id, doc.Content); err != nil {
 		return encodeError(err)
 	}
-	return m.Current()
+	return m.CurrentPage("", pageLimit)
 }
 
 // EntryUpdate updates an existing entry.
@@ -66,21 +150,224 @@ func (m *manager) EntryUpdate(id int64, text string, color int64) []byte {
 	if err := m.docs.DocumentSave(document.Identifier, document.Content); err != nil {
 		return encodeError(err)
 	}
-	return m.Current()
+	return m.CurrentPage("", pageLimit)
 }
 
-// EntryDelete deletes an existing entry.
+// EntryDelete moves an existing entry to the trash.
 func (m *manager) EntryDelete(id int64) []byte {
-	if err := m.docs.DocumentDelete(fmt.Sprintf("%d", id)); err != nil {
+	if err := m.docs.DocumentTrash(fmt.Sprintf("%d", id)); err != nil {
 		return encodeError(err)
 	}
-	return m.Current()
+	if err := m.purgeTrash(); err != nil {
+		return encodeError(err)
+	}
+	return m.CurrentPage("", pageLimit)
 }
 
 func (m *manager) EntrySearch(query string) []byte {
 	return encodeError(fmt.Errorf("search not implemented"))
 }
 
+func (m *manager) EntrySearchPage(query string, cursor string, limit int64) []byte {
+	return encodeError(fmt.Errorf("search not implemented"))
+}
+
+func (m *manager) EntrySearchRanked(query string, ranking string, cursor string, limit int64) []byte {
+	return encodeError(fmt.Errorf("search not implemented"))
+}
+
+// EntryPin pins an entry to the top of the current entries.
+func (m *manager) EntryPin(id int64) []byte {
+	if err := m.docs.DocumentPin(fmt.Sprintf("%d", id)); err != nil {
+		return encodeError(err)
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// EntryUnpin returns a pinned entry to its chronological position.
+func (m *manager) EntryUnpin(id int64) []byte {
+	if err := m.docs.DocumentUnpin(fmt.Sprintf("%d", id)); err != nil {
+		return encodeError(err)
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// EntryToggleItem checks or unchecks the checklist item at the given index,
+// counting from zero, by rewriting the entry text.
+func (m *manager) EntryToggleItem(id int64, index int64) []byte {
+	document, err := m.docs.DocumentForIdentifier(fmt.Sprintf("%d", id))
+	if err != nil {
+		return encodeError(err)
+	}
+	text, ok := checklist.Toggle(document.Content.Text, index)
+	if !ok {
+		return encodeError(NewError("NotFound", "checklist item %d of entry %d not found", index, id))
+	}
+	content := document.Content
+	content.Text = text
+	content.Modified = time.Now()
+	if err := m.docs.DocumentSave(document.Identifier, content); err != nil {
+		return encodeError(err)
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+func (m *manager) Overdue() []byte {
+	return encodeError(fmt.Errorf("dates not implemented"))
+}
+
+func (m *manager) DueToday() []byte {
+	return encodeError(fmt.Errorf("dates not implemented"))
+}
+
+func (m *manager) Upcoming(days int64) []byte {
+	return encodeError(fmt.Errorf("dates not implemented"))
+}
+
+func (m *manager) EntryAttach(id int64, data []byte, mime string) []byte {
+	return encodeError(fmt.Errorf("attachments not implemented"))
+}
+
+func (m *manager) EntryAttachments(id int64) []byte {
+	return encodeError(fmt.Errorf("attachments not implemented"))
+}
+
+func (m *manager) EntryAttachment(id int64, hash string) []byte {
+	return encodeError(fmt.Errorf("attachments not implemented"))
+}
+
+func (m *manager) EntryDetach(id int64, hash string) []byte {
+	return encodeError(fmt.Errorf("attachments not implemented"))
+}
+
+func (m *manager) Links() []byte {
+	return encodeError(fmt.Errorf("links not implemented"))
+}
+
+func (m *manager) LinkHosts() []byte {
+	return encodeError(fmt.Errorf("links not implemented"))
+}
+
+func (m *manager) EntriesLinkingTo(host string) []byte {
+	return encodeError(fmt.Errorf("links not implemented"))
+}
+
+// EntryReferences returns the links from an entry to other entries in the
+// order they appear, reporting those not matching any entry as dangling.
+func (m *manager) EntryReferences(id int64) []byte {
+	identifier := fmt.Sprintf("%d", id)
+	if _, err := m.docs.DocumentForIdentifier(identifier); err != nil {
+		return encodeError(err)
+	}
+	refs, err := m.docs.References(identifier)
+	if err != nil {
+		return encodeError(err)
+	}
+	list := make([]reference, 0, len(refs))
+	for _, ref := range refs {
+		list = append(list, reference{Reference: ref, Dangling: ref.Identifier == ""})
+	}
+	return encodeResponse(references{References: list})
+}
+
+// EntryBacklinks returns the entries linking to an entry, newest first.
+func (m *manager) EntryBacklinks(id int64) []byte {
+	identifier := fmt.Sprintf("%d", id)
+	if _, err := m.docs.DocumentForIdentifier(identifier); err != nil {
+		return encodeError(err)
+	}
+	documents, err := m.docs.DocumentsReferencing(identifier)
+	if err != nil {
+		return encodeError(err)
+	}
+	return encodeDocuments(documents)
+}
+
+func (m *manager) EntryReply(parent int64, text string, color int64) []byte {
+	return encodeError(fmt.Errorf("replies not implemented"))
+}
+
+func (m *manager) Thread(id int64) []byte {
+	return encodeError(fmt.Errorf("replies not implemented"))
+}
+
+func (m *manager) EntriesImport(data []byte) []byte {
+	return encodeError(fmt.Errorf("import not implemented"))
+}
+
+func (m *manager) Undo() []byte {
+	return encodeError(fmt.Errorf("undo not implemented"))
+}
+
+func (m *manager) Redo() []byte {
+	return encodeError(fmt.Errorf("redo not implemented"))
+}
+
+func (m *manager) SetUndoDepth(depth int64) []byte {
+	return encodeError(fmt.Errorf("undo not implemented"))
+}
+
+// Tags returns every tag starting with the given prefix. Document tags have no
+// namespaces so they're returned as a single group.
+func (m *manager) Tags(prefix string) []byte {
+	usages, err := m.docs.Tags()
+	if err != nil {
+		return encodeError(err)
+	}
+	group := tagGroup{Tags: []tagUsage{}}
+	prefix = strings.ToLower(strings.TrimPrefix(prefix, "#"))
+	for _, usage := range usages {
+		if !strings.HasPrefix(strings.ToLower(usage.Tag), prefix) {
+			continue
+		}
+		group.Tags = append(group.Tags, tagUsage{
+			ID:    usage.Tag,
+			Value: usage.Tag,
+			Count: usage.Count,
+			First: usage.First.Unix(),
+			Last:  usage.Last.Unix(),
+		})
+	}
+	groups := []tagGroup{}
+	if len(group.Tags) > 0 {
+		groups = append(groups, group)
+	}
+	return encodeResponse(catalog{Namespaces: groups})
+}
+
+// EntryRevisions returns every saved revision of an entry, newest first.
+func (m *manager) EntryRevisions(id int64) []byte {
+	document, err := m.docs.DocumentForIdentifier(fmt.Sprintf("%d", id))
+	if err != nil {
+		return encodeError(err)
+	}
+	versions := append(document.History, document.Content)
+	list := make([]revision, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		list = append(list, revision{ID: int64(i + 1), Content: versions[i]})
+	}
+	return encodeResponse(revisions{Revisions: list})
+}
+
+// EntryRevert restores an entry to the content of the given revision, which
+// moves the current content into its history.
+func (m *manager) EntryRevert(id int64, revisionID int64) []byte {
+	document, err := m.docs.DocumentForIdentifier(fmt.Sprintf("%d", id))
+	if err != nil {
+		return encodeError(err)
+	}
+	versions := append(document.History, document.Content)
+	if revisionID < 1 || revisionID > int64(len(versions)) {
+		return encodeError(NewError("NotFound", "revision %d of entry %d not found", revisionID, id))
+	}
+	content := versions[revisionID-1]
+	content.Modified = time.Now()
+	if err := m.docs.DocumentSave(document.Identifier, content); err != nil {
+		return encodeError(err)
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
 // Errors
 
 // Error represents an error.
@@ -118,9 +405,14 @@ func ErrorProgrammerFailure(message string, a ...interface{}) error {
 	return NewError("ProgrammerFailure", message, a...)
 }
 
+// ErrorInvalidCursor returns an invalid cursor Error.
+func ErrorInvalidCursor(message string, a ...interface{}) error {
+	return NewError("InvalidCursor", message, a...)
+}
+
 // Private
 
-func encodeResponse(s snapshot) []byte {
+func encodeResponse(s interface{}) []byte {
 	out, err := json.Marshal(s)
 	if err != nil {
 		return []byte(err.Error())
@@ -132,6 +424,44 @@ func encodeDocuments(documents []documents.Document) []byte {
 	return encodeResponse(snapshot{Documents: documents})
 }
 
+// encodePage trims documents fetched with one extra to the page limit and
+// lists them after the pinned documents.
+func encodePage(pinned, documents []documents.Document, limit int64) []byte {
+	var next string
+	if limit > 0 && int64(len(documents)) > limit {
+		documents = documents[:limit]
+		last := documents[len(documents)-1]
+		next = encodeCursor(last.Content.Created, last.Identifier)
+	}
+	return encodeResponse(snapshot{Documents: append(pinned, documents...), Cursor: next})
+}
+
+func encodeCursor(created time.Time, identifier string) string {
+	str := created.Format(time.RFC3339Nano) + "|" + identifier
+	return base64.RawURLEncoding.EncodeToString([]byte(str))
+}
+
+// decodeCursor returns the position described by the given cursor. An empty
+// cursor is positioned before the first document.
+func decodeCursor(str string) (time.Time, string, error) {
+	if str == "" {
+		return time.Time{}, "", nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return time.Time{}, "", ErrorInvalidCursor("failed to decode cursor: %s", err.Error())
+	}
+	parts := strings.SplitN(string(data), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrorInvalidCursor("malformed cursor '%s'", str)
+	}
+	created, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrorInvalidCursor("malformed cursor '%s'", str)
+	}
+	return created, parts[1], nil
+}
+
 func encodeError(err error) []byte {
 	s := snapshot{}
 	switch v := err.(type) {
@@ -140,7 +470,81 @@ func encodeError(err error) []byte {
 	case *Error:
 		s.Error = v
 	default:
-		s.Error = &Error{Code: "Unknown", Err: err}
+		s.Error = &Error{Code: errorCode(err), Err: err}
 	}
 	return encodeResponse(s)
 }
+
+// errorCode returns the code for errors returned by the documents package.
+func errorCode(err error) string {
+	switch err {
+	case documents.ErrLocked:
+		return "Locked"
+	case seal.ErrPassphrase:
+		return "InvalidPassphrase"
+	}
+	return "Unknown"
+}
+
+type encryption struct {
+	Encrypted bool   `json:"encrypted"`
+	Locked    bool   `json:"locked"`
+	Error     *Error `json:"error"`
+}
+
+// EncryptionState reports whether the database is encrypted and locked.
+func (m *manager) EncryptionState() []byte {
+	return encodeResponse(encryption{Encrypted: m.docs.Encrypted(), Locked: m.docs.Locked()})
+}
+
+// SetPassphrase encrypts every document with the given passphrase. When it's
+// already encrypted the current passphrase is required and documents are
+// resealed under a new data key. An empty passphrase decrypts the database.
+func (m *manager) SetPassphrase(current string, passphrase string) []byte {
+	if err := m.docs.SetPassphrase(current, passphrase); err != nil {
+		return encodeError(err)
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// Unlock opens an encrypted database with the given passphrase.
+func (m *manager) Unlock(passphrase string) []byte {
+	if err := m.docs.Unlock(passphrase); err != nil {
+		return encodeError(err)
+	}
+	return m.EncryptionState()
+}
+
+// Lock forgets the key of an encrypted database until it's unlocked again.
+func (m *manager) Lock() []byte {
+	m.docs.Lock()
+	return m.EncryptionState()
+}
+
+func (m *manager) Backup(path string) []byte {
+	return encodeError(fmt.Errorf("backup not implemented"))
+}
+
+func (m *manager) Restore(path string) []byte {
+	return encodeError(fmt.Errorf("backup not implemented"))
+}
+
+func (m *manager) Backups() []byte {
+	return encodeError(fmt.Errorf("backup not implemented"))
+}
+
+func (m *manager) SetBackupCount(count int64) []byte {
+	return encodeError(fmt.Errorf("backup not implemented"))
+}
+
+func (m *manager) ChangesSince(epoch string, seq int64) []byte {
+	return encodeError(fmt.Errorf("changes not implemented"))
+}
+
+func (m *manager) SyncCursor(peer string) []byte {
+	return encodeError(fmt.Errorf("sync not implemented"))
+}
+
+func (m *manager) SyncApply(data []byte) []byte {
+	return encodeError(fmt.Errorf("sync not implemented"))
+}
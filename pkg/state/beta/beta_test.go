@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestEntryCreate(t *testing.T) {
@@ -92,3 +93,107 @@ func TestEntryDelete(t *testing.T) {
 // 		t.Errorf(resp.Error.Error())
 // 	}
 // }
+
+func TestEntryRevert(t *testing.T) {
+	db := New(":memory:")
+	s := snapshot{}
+	if err := json.Unmarshal(db.EntryCreate("first", 0), &s); err != nil {
+		t.Fatal(err)
+	}
+	id, err := strconv.ParseInt(s.Documents[0].Identifier, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.EntryUpdate(id, "second", 0)
+
+	var r revisions
+	if err := json.Unmarshal(db.EntryRevisions(id), &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Revisions) != 2 || r.Revisions[1].Content.Text != "first" {
+		t.Fatalf("unexpected revisions (%+v)", r.Revisions)
+	}
+	if err := json.Unmarshal(db.EntryRevert(id, r.Revisions[1].ID), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil {
+		t.Fatal(s.Error.Error())
+	}
+	if s.Documents[0].Content.Text != "first" || len(s.Documents[0].History) != 2 {
+		t.Errorf("document not reverted (%+v)", s.Documents[0])
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("secret", 0)
+
+	var s snapshot
+	if err := json.Unmarshal(db.SetPassphrase("", "hunter2"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil || len(s.Documents) != 1 || s.Documents[0].Content.Text != "secret" {
+		t.Fatalf("unexpected snapshot after enabling (%+v)", s)
+	}
+	var e encryption
+	if err := json.Unmarshal(db.Lock(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Encrypted || !e.Locked {
+		t.Errorf("unexpected state after locking (%+v)", e)
+	}
+	if err := json.Unmarshal(db.Current(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "Locked" {
+		t.Errorf("expected locked error (%+v)", s.Error)
+	}
+	if err := json.Unmarshal(db.Unlock("wrong"), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Error == nil || e.Error.Code != "InvalidPassphrase" {
+		t.Errorf("expected invalid passphrase error (%+v)", e.Error)
+	}
+	if err := json.Unmarshal(db.Unlock("hunter2"), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Error != nil || !e.Encrypted || e.Locked {
+		t.Errorf("unexpected state after unlocking (%+v)", e)
+	}
+}
+
+type recorder chan notification
+
+func (r recorder) EntriesChanged(data []byte) {
+	var n notification
+	if err := json.Unmarshal(data, &n); err != nil {
+		panic(err)
+	}
+	r <- n
+}
+
+func TestObserve(t *testing.T) {
+	db := New(":memory:")
+	r := make(recorder, 10)
+	id := db.Observe(r)
+
+	db.EntryCreate("one", 0)
+	select {
+	case n := <-r:
+		if !n.Reset {
+			t.Errorf("expected reset (%+v)", n)
+		}
+	case <-time.After(3 * pollInterval):
+		t.Fatal("expected notification")
+	}
+
+	// Reads aren't notified.
+	db.Current()
+	db.Unobserve(id)
+	time.Sleep(2 * pollInterval)
+	select {
+	case n := <-r:
+		t.Errorf("unexpected notification (%+v)", n)
+	default:
+	}
+}
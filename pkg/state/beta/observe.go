@@ -0,0 +1,95 @@
+package beta
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nathanborror/logger/pkg/state"
+)
+
+// Observers
+//
+// Documents don't record which of them changed, so observers are polled for
+// and told to reload everything whenever the database has changed, whether
+// through this manager or another connection.
+
+// pollInterval is how often the database is checked for changes.
+const pollInterval = time.Second
+
+type notification struct {
+	Changes []struct{} `json:"changes"` // always empty
+	Reset   bool       `json:"reset"`   // always set, reload everything
+}
+
+type watcher struct {
+	mu        sync.Mutex
+	observers map[int64]state.Observer
+	next      int64         // id of the next observer
+	changes   int64         // the database's change count when last polled
+	stop      chan struct{} // closed to stop polling
+}
+
+// Observe registers o to be notified of changes to entries and returns an
+// id to unregister it with. Changes are noticed within pollInterval.
+func (m *manager) Observe(o state.Observer) int64 {
+	w := &m.watcher
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.observers) == 0 {
+		w.changes, _ = m.docs.Changes()
+		w.observers = map[int64]state.Observer{}
+		w.stop = make(chan struct{})
+		go m.poll(w.stop)
+	}
+	w.next++
+	w.observers[w.next] = o
+	return w.next
+}
+
+// Unobserve unregisters the observer with the given id.
+func (m *manager) Unobserve(id int64) {
+	w := &m.watcher
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.observers[id]; !ok {
+		return
+	}
+	delete(w.observers, id)
+	if len(w.observers) == 0 {
+		close(w.stop)
+	}
+}
+
+// poll notifies observers of changes until stop is closed. Observers are
+// called without holding the lock so they can use the manager.
+func (m *manager) poll(stop chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	data := encodeResponse(notification{Changes: []struct{}{}, Reset: true})
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		changes, err := m.docs.Changes()
+		if err != nil {
+			continue
+		}
+		w := &m.watcher
+		w.mu.Lock()
+		changed := changes != w.changes
+		w.changes = changes
+		observers := make([]state.Observer, 0, len(w.observers))
+		for _, o := range w.observers {
+			observers = append(observers, o)
+		}
+		w.mu.Unlock()
+		if !changed {
+			continue
+		}
+		for _, o := range observers {
+			o.EntriesChanged(data)
+		}
+	}
+}
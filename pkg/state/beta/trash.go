@@ -0,0 +1,72 @@
+package beta
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// defaultTrashRetention is the number of days entries stay in the trash
+// before they're purged unless configured otherwise.
+const defaultTrashRetention = 30
+
+const settingTrashRetention = "trash_retention" // days
+
+// Trash returns the entries in the trash, most recently deleted first.
+func (m *manager) Trash() []byte {
+	documents, err := m.docs.DocumentsTrashed()
+	if err != nil {
+		return encodeError(err)
+	}
+	return encodeDocuments(documents)
+}
+
+// EntryRestore moves an entry out of the trash.
+func (m *manager) EntryRestore(id int64) []byte {
+	if err := m.docs.DocumentRestore(fmt.Sprintf("%d", id)); err != nil {
+		return encodeError(err)
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// TrashEmpty permanently deletes every entry in the trash.
+func (m *manager) TrashEmpty() []byte {
+	if err := m.docs.DocumentsPurge(time.Now()); err != nil {
+		return encodeError(err)
+	}
+	return m.Trash()
+}
+
+// SetTrashRetention sets the number of days entries stay in the trash before
+// they're permanently deleted, zero keeps them forever.
+func (m *manager) SetTrashRetention(days int64) []byte {
+	if days < 0 {
+		days = 0
+	}
+	if err := m.docs.SetSetting(settingTrashRetention, strconv.FormatInt(days, 10)); err != nil {
+		return encodeError(err)
+	}
+	if err := m.purgeTrash(); err != nil {
+		return encodeError(err)
+	}
+	return m.Trash()
+}
+
+// purgeTrash permanently deletes entries that have been in the trash for
+// longer than the retention period.
+func (m *manager) purgeTrash() error {
+	days := int64(defaultTrashRetention)
+	value, err := m.docs.Setting(settingTrashRetention)
+	if err != nil {
+		return err
+	}
+	if value != "" {
+		if days, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return err
+		}
+	}
+	if days == 0 {
+		return nil
+	}
+	return m.docs.DocumentsPurge(time.Now().AddDate(0, 0, -int(days)))
+}
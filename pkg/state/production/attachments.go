@@ -0,0 +1,192 @@
+package production
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// attachment describes a file attached to an entry. Attachments are stored
+// once per distinct content, identified by the SHA-256 hash of their data.
+type attachment struct {
+	Hash    string `json:"hash" db:"hash"`
+	Mime    string `json:"mime" db:"mime"`
+	Size    int64  `json:"size" db:"size"`
+	Created int64  `json:"created" db:"created"` // when it was attached to the entry
+	EntryID int64  `json:"-" db:"entry_id"`
+}
+
+type attachmentList struct {
+	Attachments []attachment `json:"attachments"`
+	Error       *Error       `json:"error"`
+}
+
+type attachmentData struct {
+	Attachment attachment `json:"attachment"`
+	Data       []byte     `json:"data"` // base64 encoded
+	Error      *Error     `json:"error"`
+}
+
+// EntryAttach attaches the given data to an entry. Attaching the same data
+// to an entry again does nothing. Attachments aren't journaled and can't be
+// undone.
+func (m *manager) EntryAttach(id int64, data []byte, mime string) []byte {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	err := m.transact(func(tx *txn) error {
+		if err := requireEntry(tx, id); err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO attachment (hash, mime, size, data, created) VALUES ($1, $2, $3, $4, $5)`,
+			hash, mime, len(data), tx.sealBytes(data), now); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`INSERT OR IGNORE INTO entry_attachment (entry_id, hash, mime, created) VALUES ($1, $2, $3, $4)`, id, hash, mime, now)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return recordChange(tx, id)
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to attach"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// EntryAttachments returns the attachments of an entry, oldest first.
+func (m *manager) EntryAttachments(id int64) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var list []attachment
+	err := requireEntry(m.db, id)
+	if err == nil {
+		err = m.db.Select(&list, `
+			SELECT entry_attachment.entry_id, entry_attachment.hash, entry_attachment.mime, entry_attachment.created, attachment.size
+			FROM entry_attachment JOIN attachment ON attachment.hash = entry_attachment.hash
+			WHERE entry_attachment.entry_id = $1
+			ORDER BY entry_attachment.created, entry_attachment.rowid`, id)
+	}
+	if err != nil {
+		return encodeError(wrapError(err, "failed to get attachments"))
+	}
+	if list == nil {
+		list = []attachment{}
+	}
+	return encodeResponse(attachmentList{Attachments: list})
+}
+
+// EntryAttachment returns an attachment of an entry along with its data.
+func (m *manager) EntryAttachment(id int64, hash string) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var (
+		a    attachment
+		data []byte
+	)
+	err := m.db.Get(&a, `
+		SELECT entry_attachment.entry_id, entry_attachment.hash, entry_attachment.mime, entry_attachment.created, attachment.size
+		FROM entry_attachment JOIN attachment ON attachment.hash = entry_attachment.hash
+		WHERE entry_attachment.entry_id = $1 AND entry_attachment.hash = $2`, id, hash)
+	if err == sql.ErrNoRows {
+		err = ErrorNotFound("attachment %s of entry %d not found", hash, id)
+	} else if err == nil {
+		err = m.db.Get(&data, `SELECT data FROM attachment WHERE hash = $1`, hash)
+	}
+	if err == nil {
+		data, err = m.openBytes(data)
+	}
+	if err != nil {
+		return encodeError(wrapError(err, "failed to get attachment"))
+	}
+	return encodeResponse(attachmentData{Attachment: a, Data: data})
+}
+
+// EntryDetach removes an attachment from an entry, deleting its data when no
+// other entry uses it.
+func (m *manager) EntryDetach(id int64, hash string) []byte {
+	err := m.transact(func(tx *txn) error {
+		res, err := tx.Exec(`DELETE FROM entry_attachment WHERE entry_id = $1 AND hash = $2`, id, hash)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrorNotFound("attachment %s of entry %d not found", hash, id)
+		}
+		if err := recordChange(tx, id); err != nil {
+			return err
+		}
+		return deleteUnusedAttachments(tx)
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to detach"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// loadAttachments sets the attachments of the given entries.
+func (m *manager) loadAttachments(entries []entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	query, args, err := sqlx.In(`
+		SELECT entry_attachment.entry_id, entry_attachment.hash, entry_attachment.mime, entry_attachment.created, attachment.size
+		FROM entry_attachment JOIN attachment ON attachment.hash = entry_attachment.hash
+		WHERE entry_attachment.entry_id IN (?)
+		ORDER BY entry_attachment.created, entry_attachment.rowid`, ids)
+	if err != nil {
+		return err
+	}
+	var list []attachment
+	if err := m.db.Select(&list, query, args...); err != nil {
+		return err
+	}
+	byEntry := make(map[int64][]attachment)
+	for _, a := range list {
+		byEntry[a.EntryID] = append(byEntry[a.EntryID], a)
+	}
+	for i := range entries {
+		entries[i].Attachments = byEntry[entries[i].ID]
+	}
+	return nil
+}
+
+// requireEntry returns a NotFound error unless the entry exists and isn't in
+// the trash.
+func requireEntry(q sqlx.Queryer, id int64) error {
+	var deleted int64
+	err := sqlx.Get(q, &deleted, `SELECT deleted FROM entry WHERE id = $1`, id)
+	if err == sql.ErrNoRows || (err == nil && deleted > 0) {
+		return ErrorNotFound("entry %d not found", id)
+	}
+	return err
+}
+
+// deleteEntryAttachments removes the attachments of a deleted entry.
+func deleteEntryAttachments(tx *txn, id int64) error {
+	if _, err := tx.Exec(`DELETE FROM entry_attachment WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	return deleteUnusedAttachments(tx)
+}
+
+// deleteUnusedAttachments removes attachment data no longer attached to any
+// entry.
+func deleteUnusedAttachments(tx *txn) error {
+	_, err := tx.Exec(`DELETE FROM attachment WHERE NOT EXISTS (SELECT 1 FROM entry_attachment WHERE entry_attachment.hash = attachment.hash)`)
+	return err
+}
@@ -0,0 +1,67 @@
+package production
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestAttachments(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("receipt", 0)
+	db.EntryCreate("receipt again", 0)
+	photo := []byte("\x89PNG not really")
+
+	var s snapshot
+	if err := json.Unmarshal(db.EntryAttach(1, photo, "image/png"), &s); err != nil {
+		t.Fatal(err)
+	}
+	db.EntryAttach(1, photo, "image/png")
+	db.EntryAttach(2, photo, "image/png")
+	db.EntryAttach(2, []byte("%PDF"), "application/pdf")
+	if err := json.Unmarshal(db.Current(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries[1].Attachments) != 1 || len(s.Entries[0].Attachments) != 2 {
+		t.Fatalf("unexpected attachments (%+v)", s.Entries)
+	}
+	hash := s.Entries[1].Attachments[0].Hash
+
+	var list attachmentList
+	if err := json.Unmarshal(db.EntryAttachments(2), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Attachments) != 2 || list.Attachments[0].Hash != hash || list.Attachments[1].Mime != "application/pdf" {
+		t.Errorf("unexpected attachment list (%+v)", list.Attachments)
+	}
+
+	var data attachmentData
+	if err := json.Unmarshal(db.EntryAttachment(1, hash), &data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data.Data, photo) || data.Attachment.Size != int64(len(photo)) {
+		t.Errorf("unexpected attachment (%+v)", data)
+	}
+
+	// Shared data survives until the last entry using it is gone.
+	count := func() (n int) {
+		db.(*manager).db.Get(&n, `SELECT count(*) FROM attachment`)
+		return n
+	}
+	db.EntryDetach(1, hash)
+	if n := count(); n != 2 {
+		t.Errorf("attachments != 2 (%d)", n)
+	}
+	db.EntryDelete(2)
+	db.TrashEmpty()
+	if n := count(); n != 0 {
+		t.Errorf("orphaned attachments remain (%d)", n)
+	}
+
+	if err := json.Unmarshal(db.EntryAttachment(1, hash), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "NotFound" {
+		t.Errorf("expected NotFound error (%+v)", s.Error)
+	}
+}
@@ -0,0 +1,348 @@
+package production
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/nathanborror/logger/pkg/migrate"
+)
+
+// defaultBackupCount is the number of automatic backups kept unless
+// configured otherwise.
+const defaultBackupCount = 5
+
+// backupInterval is the minimum time between automatic backups.
+const backupInterval = time.Hour
+
+// backupLayout is the timestamp in the file names of automatic backups, it
+// sorts chronologically.
+const backupLayout = "20060102-150405"
+
+type backup struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Created int64  `json:"created"`
+	Error   *Error `json:"error"`
+}
+
+type backupList struct {
+	Backups []backup `json:"backups"`
+	Error   *Error   `json:"error"`
+}
+
+// Backup copies the database to the file at path. It uses SQLite's online
+// backup so it's safe while the database is in use. Encrypted content stays
+// encrypted in the copy.
+func (m *manager) Backup(path string) []byte {
+	if err := backupDatabase(m.db, path); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to back up: %s", err.Error()))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to back up: %s", err.Error()))
+	}
+	return encodeResponse(backup{Path: path, Size: info.Size(), Created: info.ModTime().Unix()})
+}
+
+// Restore replaces the database with the backup at path after checking the
+// backup's integrity and that it holds entries with a schema this version
+// understands. The current database is backed up first, alongside the
+// automatic backups. Restoring an encrypted backup leaves the database locked.
+// The change feed gets a new epoch so peers start over, and the database gets
+// a new device so it isn't mistaken for the one the backup was taken on.
+func (m *manager) Restore(path string) []byte {
+	src, err := openBackup(path)
+	if err != nil {
+		return encodeError(wrapError(err, "failed to open backup"))
+	}
+	defer src.Close()
+
+	if dir := m.backupDir(); dir != "" {
+		if _, err := m.autoBackup(dir, 0); err != nil {
+			return encodeError(ErrorProgrammerFailure("failed to back up: %s", err.Error()))
+		}
+	}
+	if err := m.run(dropIndex); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to restore: %s", err.Error()))
+	}
+	m.box = nil
+	if err := copyDatabase(m.db, src); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to restore: %s", err.Error()))
+	}
+	if err := migrate.Run(m.db, migrations); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to migrate backup: %s", err.Error()))
+	}
+	// The change feed goes back to the backup's sequence, which peers may
+	// already have seen numbers beyond. The backup may come from another
+	// device still syncing under its own device.
+	err = m.run(func(tx *txn) error {
+		_, err := tx.Exec(`UPDATE setting SET value = lower(hex(randomblob(8))) WHERE key IN ($1, $2)`, settingFeedEpoch, settingDevice)
+		return err
+	})
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to restore: %s", err.Error()))
+	}
+	if m.keyring, err = loadKeyring(m.db); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to restore: %s", err.Error()))
+	}
+	m.notify(true)
+	return m.CurrentPage("", pageLimit)
+}
+
+// Backups returns the automatic backups, newest first.
+func (m *manager) Backups() []byte {
+	dir := m.backupDir()
+	if dir == "" {
+		return encodeResponse(backupList{Backups: []backup{}})
+	}
+	backups, err := m.listBackups(dir)
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to list backups: %s", err.Error()))
+	}
+	return encodeResponse(backupList{Backups: backups})
+}
+
+// SetBackupCount sets the number of automatic backups kept, discarding older
+// ones. A count of zero disables automatic backups.
+func (m *manager) SetBackupCount(count int64) []byte {
+	if count < 0 {
+		count = 0
+	}
+	err := m.run(func(tx *txn) error {
+		return setSetting(tx, settingBackupCount, strconv.FormatInt(count, 10))
+	})
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to set backup count: %s", err.Error()))
+	}
+	if dir := m.backupDir(); dir != "" {
+		if err := m.rotateBackups(dir, count); err != nil {
+			return encodeError(ErrorProgrammerFailure("failed to remove backups: %s", err.Error()))
+		}
+	}
+	return m.Backups()
+}
+
+// autoBackup backs up the database into dir unless the newest backup there
+// is less than interval old or the database hasn't changed since, then
+// discards backups beyond the backup count. It reports whether a backup was
+// made.
+func (m *manager) autoBackup(dir string, interval time.Duration) (bool, error) {
+	count, err := m.backupCount()
+	if err != nil || count == 0 {
+		return false, err
+	}
+	info, err := os.Stat(m.name)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() == 0 {
+		return false, nil // nothing to back up yet
+	}
+	backups, err := m.listBackups(dir)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if len(backups) > 0 {
+		newest, err := os.Stat(backups[0].Path)
+		if err != nil {
+			return false, err
+		}
+		if now.Sub(time.Unix(backups[0].Created, 0)) < interval || info.ModTime().Before(newest.ModTime()) {
+			return false, nil
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return false, err
+	}
+	name := fmt.Sprintf("%s-%s.db", m.backupPrefix(), now.Format(backupLayout))
+	if err := backupDatabase(m.db, filepath.Join(dir, name)); err != nil {
+		return false, err
+	}
+	return true, m.rotateBackups(dir, count)
+}
+
+// backupCount returns the number of automatic backups kept. Databases are
+// backed up before they're migrated so the setting table may not exist yet.
+func (m *manager) backupCount() (int64, error) {
+	var exists bool
+	if err := m.db.Get(&exists, `SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'setting'`); err != nil || !exists {
+		return defaultBackupCount, err
+	}
+	return settingInt(m.db, settingBackupCount, defaultBackupCount)
+}
+
+// rotateBackups removes all but the newest count automatic backups.
+func (m *manager) rotateBackups(dir string, count int64) error {
+	backups, err := m.listBackups(dir)
+	if err != nil {
+		return err
+	}
+	for i := int(count); i < len(backups); i++ {
+		if err := os.Remove(backups[i].Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceBackups removes every automatic backup in dir and backs up the
+// database in their place.
+func (m *manager) replaceBackups(dir string) error {
+	if err := m.rotateBackups(dir, 0); err != nil {
+		return err
+	}
+	_, err := m.autoBackup(dir, 0)
+	return err
+}
+
+// listBackups returns the automatic backups in dir, newest first.
+func (m *manager) listBackups(dir string) ([]backup, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, m.backupPrefix()+"-*.db"))
+	if err != nil {
+		return nil, err
+	}
+	backups := []backup{}
+	for _, path := range paths {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), m.backupPrefix()+"-"), ".db")
+		created, err := time.ParseInLocation(backupLayout, stamp, time.Local)
+		if err != nil {
+			continue // not an automatic backup
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup{Path: path, Size: info.Size(), Created: created.Unix()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Path > backups[j].Path
+	})
+	return backups, nil
+}
+
+// backupDir returns the directory holding automatic backups, next to the
+// database file, or an empty string for in-memory databases.
+func (m *manager) backupDir() string {
+	if m.name == "" || m.name == ":memory:" || strings.HasPrefix(m.name, "file:") {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(m.name), "backups")
+}
+
+func (m *manager) backupPrefix() string {
+	base := filepath.Base(m.name)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// backupDatabase copies db to a new file at path. The copy is written beside
+// path and moved into place once it's complete.
+func backupDatabase(db *sqlx.DB, path string) error {
+	partial := path + ".partial"
+	os.Remove(partial)
+	dst, err := sqlx.Open("sqlite3", fileDSN(partial, ""))
+	if err != nil {
+		return err
+	}
+	err = copyDatabase(dst, db)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+// fileDSN returns the URI for the database file at path with the given
+// query, escaping characters SQLite would otherwise read as parameters.
+// Relative paths are made absolute so they aren't read as a host.
+func fileDSN(path string, query string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	u := url.URL{Scheme: "file", Path: path, RawQuery: query}
+	return u.String()
+}
+
+// openBackup opens the backup at path read-only, checking its integrity and
+// that its schema is one this version can migrate.
+func openBackup(path string) (*sqlx.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, ErrorNotFound("backup not found: %s", err.Error())
+	}
+	db, err := sqlx.Open("sqlite3", fileDSN(path, "mode=ro"))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := checkBackup(db); err != nil {
+		db.Close()
+		return nil, ErrorInvalidBackup(err.Error())
+	}
+	return db, nil
+}
+
+func checkBackup(db *sqlx.DB) error {
+	var result string
+	if err := db.Get(&result, `PRAGMA integrity_check(1)`); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	version, err := migrate.Version(db)
+	if err != nil {
+		return err
+	}
+	if version < 1 || version > len(migrations) {
+		return fmt.Errorf("unsupported schema version %d", version)
+	}
+	var tables int
+	if err := db.Get(&tables, `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'entry'`); err != nil {
+		return err
+	}
+	if tables == 0 {
+		return fmt.Errorf("not an entries database")
+	}
+	return nil
+}
+
+// copyDatabase replaces the contents of dst with src using SQLite's online
+// backup.
+func copyDatabase(dst, src *sqlx.DB) error {
+	ctx := context.Background()
+	dconn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dconn.Close()
+	sconn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sconn.Close()
+	return dconn.Raw(func(d interface{}) error {
+		return sconn.Raw(func(s interface{}) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
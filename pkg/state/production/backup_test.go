@@ -0,0 +1,237 @@
+package production
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nathanborror/logger/pkg/migrate"
+)
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	db := New(filepath.Join(dir, "data.logger"))
+	db.EntryCreate("one", 0)
+	db.EntryCreate("two", 0)
+
+	path := filepath.Join(dir, "copy.logger")
+	var b backup
+	if err := json.Unmarshal(db.Backup(path), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Error != nil || b.Path != path || b.Size == 0 {
+		t.Fatalf("unexpected backup (%+v)", b)
+	}
+	var s snapshot
+	if err := json.Unmarshal(New(path).Current(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 2 {
+		t.Fatalf("unexpected entries in backup (%v)", ids)
+	}
+
+	// Restoring replaces the database after backing up the current one.
+	db.EntryCreate("three", 0)
+	if err := json.Unmarshal(db.Restore(path), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); s.Error != nil || len(ids) != 2 {
+		t.Fatalf("unexpected entries after restore (%v, %+v)", ids, s.Error)
+	}
+	if err := json.Unmarshal(db.EntrySearch("three"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 0 {
+		t.Errorf("expected search index to be restored (%v)", entryIDs(s.Entries))
+	}
+	var list backupList
+	if err := json.Unmarshal(db.Backups(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Backups) == 0 {
+		t.Fatalf("expected backup before restore (%+v)", list)
+	}
+	if err := json.Unmarshal(New(list.Backups[0].Path).Current(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 3 {
+		t.Errorf("unexpected entries in automatic backup (%v)", ids)
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	dir := t.TempDir()
+	db := New(":memory:")
+	db.EntryCreate("one", 0)
+
+	garbage := filepath.Join(dir, "garbage.logger")
+	if err := ioutil.WriteFile(garbage, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for path, code := range map[string]string{
+		garbage:                          "InvalidBackup",
+		filepath.Join(dir, "missing.db"): "NotFound",
+	} {
+		var s snapshot
+		if err := json.Unmarshal(db.Restore(path), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error == nil || s.Error.Code != code {
+			t.Errorf("expected %s restoring %s (%+v)", code, path, s.Error)
+		}
+	}
+	var s snapshot
+	if err := json.Unmarshal(db.Current(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 1 {
+		t.Errorf("database changed by failed restore (%v)", entryIDs(s.Entries))
+	}
+}
+
+func TestRestoreOtherDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "copy.logger")
+	a := New(":memory:")
+	a.EntryCreate("one", 0)
+	a.Backup(path)
+
+	b := New(":memory:")
+	var s snapshot
+	if err := json.Unmarshal(b.Restore(path), &s); err != nil || s.Error != nil {
+		t.Fatalf("failed to restore (%v, %+v)", err, s.Error)
+	}
+	var ca, cb syncCursor
+	json.Unmarshal(a.SyncCursor(""), &ca)
+	json.Unmarshal(b.SyncCursor(""), &cb)
+	if ca.Device == "" || ca.Device == cb.Device {
+		t.Fatalf("expected restored database to get its own device (%s, %s)", ca.Device, cb.Device)
+	}
+
+	// The device the backup came from keeps syncing with it.
+	a.EntryUpdate(1, "one again", 0)
+	var summary syncSummary
+	json.Unmarshal(b.SyncApply(a.ChangesSince("", 0)), &summary)
+	if summary.Error != nil || summary.Updated != 1 {
+		t.Errorf("unexpected sync after restore (%+v)", summary)
+	}
+}
+
+func TestAutoBackup(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "data.logger")
+	db := New(name)
+	db.EntryCreate("one", 0)
+	db.SetBackupCount(2)
+
+	// Opening an existing database backs it up.
+	m := New(name).(*manager)
+	backups := filepath.Join(dir, "backups")
+	var list backupList
+	if err := json.Unmarshal(m.Backups(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Backups) != 1 {
+		t.Fatalf("expected backup on open (%+v)", list)
+	}
+	if made, err := m.autoBackup(backups, backupInterval); err != nil || made {
+		t.Errorf("expected recent backup to be kept (%v, %v)", made, err)
+	}
+	if made, err := m.autoBackup(backups, 0); err != nil || made {
+		t.Errorf("expected unchanged database to be skipped (%v, %v)", made, err)
+	}
+
+	// Only the newest backups are kept.
+	data, err := ioutil.ReadFile(list.Backups[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, day := range []string{"20210101", "20210102", "20210103"} {
+		path := filepath.Join(backups, "data-"+day+"-120000.db")
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		made, _ := time.ParseInLocation("20060102", day, time.Local)
+		if err := os.Chtimes(path, made, made); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(backups, "data-notes.db"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(list.Backups[0].Path)
+	if made, err := m.autoBackup(backups, backupInterval); err != nil || !made {
+		t.Fatalf("expected backup (%v, %v)", made, err)
+	}
+	if err := json.Unmarshal(m.Backups(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Backups) != 2 || time.Since(time.Unix(list.Backups[0].Created, 0)) > time.Minute ||
+		filepath.Base(list.Backups[1].Path) != "data-20210103-120000.db" {
+		t.Errorf("unexpected backups (%+v)", list.Backups)
+	}
+	if _, err := os.Stat(filepath.Join(backups, "data-notes.db")); err != nil {
+		t.Errorf("expected unrelated file to be kept (%v)", err)
+	}
+
+	if err := json.Unmarshal(m.SetBackupCount(0), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Backups) != 0 {
+		t.Errorf("expected backups to be removed (%+v)", list.Backups)
+	}
+}
+
+func TestAutoBackupBeforeMigrating(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "data.logger")
+	conn, err := sqlx.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate.Run(conn, migrations[:7]); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	m := New(name).(*manager)
+	var list backupList
+	if err := json.Unmarshal(m.Backups(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Backups) != 1 {
+		t.Fatalf("expected backup on open (%+v)", list)
+	}
+	conn, err = sqlx.Open("sqlite3", list.Backups[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var version int
+	if err := conn.Get(&version, `PRAGMA user_version`); err != nil || version != 7 {
+		t.Errorf("expected backup of unmigrated database (%d, %v)", version, err)
+	}
+}
+
+func TestRestorePathEscaping(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a?b#c%d")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	db := New(":memory:")
+	db.EntryCreate("one", 0)
+	path := filepath.Join(dir, "copy.logger")
+	db.Backup(path)
+	db.EntryCreate("two", 0)
+
+	var s snapshot
+	if err := json.Unmarshal(db.Restore(path), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); s.Error != nil || len(ids) != 1 {
+		t.Errorf("unexpected entries after restore (%v, %+v)", ids, s.Error)
+	}
+}
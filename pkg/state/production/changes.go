@@ -0,0 +1,166 @@
+package production
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Change feed
+//
+// Every entry that's created, changed or permanently deleted is given the
+// next number of a change sequence by triggers on the entry table, or by
+// recordChange for changes kept in other tables. Only the latest change of
+// each entry is kept so the feed never grows beyond the number of entries
+// ever created. Deleted entries leave a tombstone, which is all that's known
+// of entries deleted on another device before they were synced here. The
+// feed has a random epoch that's replaced when restoring a backup, since
+// sequence numbers are reused once the feed goes back.
+
+// changesLimit is the maximum number of changes returned at once.
+const changesLimit = 500
+
+type changes struct {
+	Device     string      `json:"device"`     // the database the changes were made in
+	Epoch      string      `json:"epoch"`      // the epoch seq belongs to
+	Entries    []entry     `json:"entries"`    // created or changed entries, including those in the trash
+	Deleted    []int64     `json:"deleted"`    // ids of permanently deleted entries
+	Tombstones []tombstone `json:"tombstones"` // permanently deleted entries by uid
+	Seq        int64       `json:"seq"`        // sequence of the last change returned
+	More       bool        `json:"more"`       // whether there are changes after seq
+	Reset      bool        `json:"reset"`      // whether the feed started over from the beginning
+	Error      *Error      `json:"error"`
+}
+
+type change struct {
+	Seq       int64          `db:"seq"`
+	EntryID   sql.NullInt64  `db:"entry_id"` // set for entries that exist
+	UID       sql.NullString `db:"uid"`      // set for tombstones
+	Version   clock          `db:"version"`
+	Deleted   bool           `db:"deleted"`
+	Kind      string         `db:"kind"`       // create, update or delete, including to and from the trash
+	DeletedID sql.NullInt64  `db:"deleted_id"` // the id a tombstone's entry had here
+}
+
+// tombstone is what remains of a permanently deleted entry.
+type tombstone struct {
+	UID     string `json:"uid" db:"uid"`
+	Version clock  `json:"version" db:"version"`
+}
+
+// ChangesSince returns the entries changed or deleted after the given
+// sequence, oldest change first, along with the epoch and sequence to ask
+// for next. A sequence from another epoch, which happens after restoring a
+// backup, starts the feed over and sets reset so callers know to reconcile.
+// An empty epoch is only expected with a sequence of zero.
+func (m *manager) ChangesSince(epoch string, seq int64) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var latest int64
+	if err := m.db.Get(&latest, `SELECT coalesce(max(seq), 0) FROM entry_change`); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get changes: %s", err.Error()))
+	}
+	resp := changes{Entries: []entry{}, Deleted: []int64{}, Tombstones: []tombstone{}, Seq: seq}
+	if err := m.db.Get(&resp.Device, `SELECT value FROM setting WHERE key = $1`, settingDevice); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get device: %s", err.Error()))
+	}
+	if err := m.db.Get(&resp.Epoch, `SELECT value FROM setting WHERE key = $1`, settingFeedEpoch); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get feed epoch: %s", err.Error()))
+	}
+	if (seq > 0 && epoch != resp.Epoch) || seq > latest {
+		resp.Seq, resp.Reset = 0, true
+	}
+
+	var feed []change
+	if err := m.db.Select(&feed, `SELECT * FROM entry_change WHERE seq > $1 ORDER BY seq LIMIT $2`, resp.Seq, changesLimit+1); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get changes: %s", err.Error()))
+	}
+	if len(feed) > changesLimit {
+		feed, resp.More = feed[:changesLimit], true
+	}
+	var (
+		ids      []int64
+		position = map[int64]int{}
+	)
+	for i, c := range feed {
+		resp.Seq = c.Seq
+		switch {
+		case !c.Deleted:
+			ids = append(ids, c.EntryID.Int64)
+			position[c.EntryID.Int64] = i
+		case c.DeletedID.Valid:
+			resp.Deleted = append(resp.Deleted, c.DeletedID.Int64)
+		}
+		if c.Deleted && c.UID.Valid {
+			resp.Tombstones = append(resp.Tombstones, tombstone{UID: c.UID.String, Version: c.Version})
+		}
+	}
+	if len(ids) == 0 {
+		return encodeResponse(resp)
+	}
+
+	query, args, err := sqlx.In(`SELECT * FROM entry WHERE id IN (?)`, ids)
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get changes: %s", err.Error()))
+	}
+	if err := m.db.Select(&resp.Entries, query, args...); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get changed entries: %s", err.Error()))
+	}
+	sort.Slice(resp.Entries, func(i, j int) bool {
+		return position[resp.Entries[i].ID] < position[resp.Entries[j].ID]
+	})
+	if err := m.loadDetails(resp.Entries); err != nil {
+		return encodeError(wrapError(err, "failed to get entry details"))
+	}
+	if err := m.loadParentUIDs(resp.Entries); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get entry parents: %s", err.Error()))
+	}
+	// Changed entries keep their stored text, hashtags and all, so they can be
+	// copied elsewhere as they are.
+	resp.Entries = encodeSnapshot(resp.Entries, "").Entries
+	for i, e := range resp.Entries {
+		resp.Entries[i].Text = e.Source
+	}
+	return encodeResponse(resp)
+}
+
+// recordChange adds an update of an entry to the change feed for changes
+// that leave the entry's row alone, such as attaching files.
+func recordChange(tx *txn, id int64) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO entry_change (entry_id, deleted, kind) VALUES ($1, 0, 'update')`, id)
+	return err
+}
+
+// loadParentUIDs sets the uid of the entries replies belong to.
+func (m *manager) loadParentUIDs(entries []entry) error {
+	var parents []int64
+	for _, e := range entries {
+		if e.ParentID != 0 {
+			parents = append(parents, e.ParentID)
+		}
+	}
+	if len(parents) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In(`SELECT id, uid FROM entry WHERE id IN (?)`, parents)
+	if err != nil {
+		return err
+	}
+	var rows []struct {
+		ID  int64  `db:"id"`
+		UID string `db:"uid"`
+	}
+	if err := m.db.Select(&rows, query, args...); err != nil {
+		return err
+	}
+	uids := map[int64]string{}
+	for _, r := range rows {
+		uids[r.ID] = r.UID
+	}
+	for i, e := range entries {
+		entries[i].ParentUID = uids[e.ParentID]
+	}
+	return nil
+}
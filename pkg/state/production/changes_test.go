@@ -0,0 +1,143 @@
+package production
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestChangesSince(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("one #work", 0)
+	db.EntryCreate("two", 0)
+	db.EntryCreate("three", 0)
+
+	var c changes
+	if err := json.Unmarshal(db.ChangesSince("", 0), &c); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(c.Entries); len(ids) != 3 || ids[0] != 1 || ids[2] != 3 || c.Seq != 3 || c.More || c.Reset {
+		t.Fatalf("unexpected changes (%v, %+v)", ids, c)
+	}
+	if c.Entries[0].Text != "one #work" || len(c.Entries[0].Tags) != 1 {
+		t.Errorf("expected stored text and tags (%+v)", c.Entries[0])
+	}
+	seq := c.Seq
+
+	// Each entry appears once at the position of its latest change.
+	db.EntryUpdate(1, "one again", 0)
+	db.EntryDelete(2)
+	db.EntryUpdate(1, "one more time", 0)
+	if err := json.Unmarshal(db.ChangesSince(c.Epoch, seq), &c); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(c.Entries); len(ids) != 2 || ids[0] != 2 || ids[1] != 1 || len(c.Deleted) != 0 {
+		t.Fatalf("unexpected changes (%v, %v)", ids, c.Deleted)
+	}
+	if c.Entries[0].Deleted == 0 || c.Entries[1].Text != "one more time" {
+		t.Errorf("unexpected changed entries (%+v)", c.Entries)
+	}
+	seq = c.Seq
+
+	// Permanently deleted entries leave a tombstone.
+	db.TrashEmpty()
+	if err := json.Unmarshal(db.ChangesSince(c.Epoch, seq), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Entries) != 0 || len(c.Deleted) != 1 || c.Deleted[0] != 2 || c.Seq <= seq {
+		t.Fatalf("unexpected changes after purge (%+v)", c)
+	}
+	if err := json.Unmarshal(db.ChangesSince(c.Epoch, c.Seq), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Entries) != 0 || len(c.Deleted) != 0 {
+		t.Errorf("expected no changes (%+v)", c)
+	}
+
+	// A sequence from the future starts over.
+	if err := json.Unmarshal(db.ChangesSince(c.Epoch, c.Seq+100), &c); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Reset || len(c.Entries) != 2 || len(c.Deleted) != 1 {
+		t.Errorf("expected feed to start over (%+v)", c)
+	}
+
+	// So does a sequence from another epoch.
+	if err := json.Unmarshal(db.ChangesSince("elsewhere", c.Seq), &c); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Reset || len(c.Entries) != 2 {
+		t.Errorf("expected feed to start over (%+v)", c)
+	}
+}
+
+func TestChangesSinceReusedID(t *testing.T) {
+	db := New(":memory:")
+	m := db.(*manager)
+	var s snapshot
+	json.Unmarshal(db.EntryCreate("one", 0), &s)
+	uid := s.Entries[0].UID
+	db.EntryDelete(1)
+	db.TrashEmpty()
+
+	// Entries brought back by undo and redo are inserted with their old id.
+	m.db.MustExec(`INSERT INTO entry (id, text, color, created, modified) VALUES (1, 'other', 0, 1, 1)`)
+	var c changes
+	if err := json.Unmarshal(db.ChangesSince("", 0), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Tombstones) != 1 || c.Tombstones[0].UID != uid || len(c.Entries) != 1 {
+		t.Errorf("expected tombstone to be kept (%+v)", c)
+	}
+}
+
+func TestChangesSinceRestore(t *testing.T) {
+	dir := t.TempDir()
+	db := New(filepath.Join(dir, "data.logger"))
+	db.EntryCreate("one", 0)
+	path := filepath.Join(dir, "copy.logger")
+	db.Backup(path)
+	db.EntryCreate("two", 0)
+
+	var c changes
+	if err := json.Unmarshal(db.ChangesSince("", 0), &c); err != nil {
+		t.Fatal(err)
+	}
+	epoch, seq := c.Epoch, c.Seq
+
+	// Restoring goes back to the backup's sequence, so the next change reuses
+	// a sequence already seen.
+	db.Restore(path)
+	db.EntryCreate("three", 0)
+	if err := json.Unmarshal(db.ChangesSince(epoch, seq), &c); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(c.Entries); !c.Reset || c.Epoch == epoch || len(ids) != 2 {
+		t.Errorf("expected feed to start over after restore (%v, %+v)", ids, c)
+	}
+}
+
+func TestChangesSinceLimit(t *testing.T) {
+	db := New(":memory:")
+	tx := db.(*manager).db.MustBegin()
+	for i := 0; i < changesLimit+10; i++ {
+		tx.MustExec(`INSERT INTO entry (text, color, created, modified) VALUES ('entry', 0, 1, 1)`)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var c changes
+	if err := json.Unmarshal(db.ChangesSince("", 0), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Entries) != changesLimit || !c.More {
+		t.Fatalf("unexpected first batch (%d, %v)", len(c.Entries), c.More)
+	}
+	if err := json.Unmarshal(db.ChangesSince(c.Epoch, c.Seq), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Entries) != 10 || c.More {
+		t.Errorf("unexpected second batch (%d, %v)", len(c.Entries), c.More)
+	}
+}
@@ -0,0 +1,30 @@
+package production
+
+import (
+	"github.com/nathanborror/logger/pkg/checklist"
+)
+
+// EntryToggleItem checks or unchecks the checklist item at the given index,
+// counting from zero, by rewriting the entry text.
+func (m *manager) EntryToggleItem(id int64, index int64) []byte {
+	err := m.transact(func(tx *txn) error {
+		return journaled(tx, journalToggle, id, func() error {
+			e, err := loadEntry(tx, id)
+			if err != nil {
+				return err
+			}
+			if e == nil || e.Deleted > 0 {
+				return ErrorNotFound("entry %d not found", id)
+			}
+			text, ok := checklist.Toggle(e.Text, index)
+			if !ok {
+				return ErrorNotFound("checklist item %d of entry %d not found", index, id)
+			}
+			return updateEntry(tx, id, text, e.Color, 0)
+		})
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to toggle checklist item"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
@@ -0,0 +1,42 @@
+package production
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEntryToggleItem(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("Groceries #errands\n- [ ] milk\n- [x] eggs #dairy", 0)
+
+	var s snapshot
+	if err := json.Unmarshal(db.Current(), &s); err != nil {
+		t.Fatal(err)
+	}
+	items := s.Entries[0].Checklist
+	if len(items) != 2 || items[0].Text != "milk" || items[0].Checked || items[1].Text != "eggs" || !items[1].Checked {
+		t.Fatalf("unexpected checklist (%+v)", items)
+	}
+
+	if err := json.Unmarshal(db.EntryToggleItem(1, 0), &s); err != nil {
+		t.Fatal(err)
+	}
+	if items := s.Entries[0].Checklist; !items[0].Checked {
+		t.Errorf("item not checked (%+v)", items)
+	}
+	var revisions struct{ Revisions []revision }
+	if err := json.Unmarshal(db.EntryRevisions(1), &revisions); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions.Revisions) != 2 || !strings.Contains(revisions.Revisions[0].Text, "- [x] milk") {
+		t.Errorf("toggle not recorded as a revision (%+v)", revisions.Revisions)
+	}
+
+	if err := json.Unmarshal(db.EntryToggleItem(1, 2), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "NotFound" {
+		t.Errorf("expected NotFound error (%+v)", s.Error)
+	}
+}
@@ -0,0 +1,122 @@
+package production
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nathanborror/logger/pkg/documents"
+	"github.com/nathanborror/logger/pkg/migrate"
+)
+
+// Conversion summarizes converting entries to documents.
+type Conversion struct {
+	Converted int      `json:"converted"` // entries copied for the first time
+	Updated   int      `json:"updated"`   // entries modified since a previous conversion
+	Skipped   int      `json:"skipped"`   // entries already converted
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors"`
+	Error     *Error   `json:"error"`
+}
+
+// Convert copies the entries of the production database at src into the
+// documents database at dst and returns a summary of the conversion.
+func Convert(src, dst string) []byte {
+	docs, err := documents.New(dst)
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to open documents: %s", err.Error()))
+	}
+	defer docs.Close()
+	conversion, err := ConvertToDocuments(src, docs)
+	if err != nil {
+		return encodeError(err)
+	}
+	return encodeResponse(conversion)
+}
+
+// ConvertToDocuments copies every entry of the production database at name
+// into docs, preserving ids and timestamps and keeping hashtags as document
+// tags. Entries in the trash aren't converted. Each entry is saved on its own
+// so an interrupted conversion can be resumed by running it again; entries
+// that haven't changed since they were last converted are skipped. Entries
+// that fail to convert are reported in the summary rather than stopping the
+// conversion. Encrypted databases can't be converted. The database at name
+// is only read, see openSource.
+func ConvertToDocuments(name string, docs *documents.Documents) (*Conversion, error) {
+	m, err := openSource(name)
+	if err != nil {
+		return nil, wrapError(err, "failed to open entries")
+	}
+	defer m.db.Close()
+	if m.keyring != nil {
+		return nil, ErrorLocked("encrypted entries can't be converted, remove the passphrase first")
+	}
+
+	var entries []entry
+	if err := m.db.Select(&entries, `SELECT * FROM entry WHERE deleted = 0 ORDER BY id`); err != nil {
+		return nil, ErrorProgrammerFailure("failed to get entries: %s", err.Error())
+	}
+	conversion := &Conversion{Errors: []string{}}
+	for _, entry := range entries {
+		id := strconv.FormatInt(entry.ID, 10)
+		existing, _ := docs.DocumentForIdentifier(id)
+		if existing != nil && existing.Content.Modified.Unix() >= entry.Modified {
+			conversion.Skipped++
+			continue
+		}
+		if err := docs.DocumentSave(id, convertEntry(entry)); err != nil {
+			conversion.Failed++
+			conversion.Errors = append(conversion.Errors, "entry "+id+": "+err.Error())
+			continue
+		}
+		if existing != nil {
+			conversion.Updated++
+		} else {
+			conversion.Converted++
+		}
+	}
+	return conversion, nil
+}
+
+// openSource opens a copy of the database at name in memory, migrated to the
+// latest schema, leaving the database itself untouched.
+func openSource(name string) (*manager, error) {
+	if _, err := os.Stat(name); err != nil {
+		return nil, ErrorNotFound("entries not found: %s", err.Error())
+	}
+	src, err := sqlx.Open("sqlite3", fileDSN(name, "mode=ro"))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	m, err := open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	if err := copyDatabase(m.db, src); err != nil {
+		m.db.Close()
+		return nil, err
+	}
+	if err := migrate.Run(m.db, migrations); err != nil {
+		m.db.Close()
+		return nil, err
+	}
+	if m.keyring, err = loadKeyring(m.db); err != nil {
+		m.db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func convertEntry(e entry) documents.Content {
+	doc := documents.NewDocument()
+	doc.Content.Text = e.Text
+	doc.Content.Created = time.Unix(e.Created, 0)
+	doc.Content.Modified = time.Unix(e.Modified, 0)
+	doc.Content.Meta.Color = e.Color
+	for _, tag := range encodeEntryTags(e.Text) {
+		doc.Content.Meta.Tags = append(doc.Content.Meta.Tags, tag.ID)
+	}
+	return doc.Content
+}
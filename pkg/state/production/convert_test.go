@@ -0,0 +1,88 @@
+package production
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nathanborror/logger/pkg/documents"
+	"github.com/nathanborror/logger/pkg/migrate"
+)
+
+func TestConvertToDocuments(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data.logger")
+	db := New(name)
+	db.EntryCreate("buy milk #errands", 1)
+	db.EntryCreate("fix the build #work", 2)
+
+	docs, err := documents.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversion, err := ConvertToDocuments(name, docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conversion.Converted != 2 || conversion.Failed != 0 {
+		t.Errorf("unexpected conversion (%+v)", conversion)
+	}
+	doc, err := docs.DocumentForIdentifier("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content.Text != "buy milk #errands" || doc.Content.Meta.Color != 1 {
+		t.Errorf("unexpected document content (%+v)", doc.Content)
+	}
+	if len(doc.Content.Meta.Tags) != 1 || doc.Content.Meta.Tags[0] != "errands" {
+		t.Errorf("unexpected document tags (%v)", doc.Content.Meta.Tags)
+	}
+
+	db.(*manager).db.MustExec(`UPDATE entry SET text = 'fix the tests #work', modified = modified + 1 WHERE id = 2`)
+	conversion, err = ConvertToDocuments(name, docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conversion.Converted != 0 || conversion.Updated != 1 || conversion.Skipped != 1 {
+		t.Errorf("unexpected second conversion (%+v)", conversion)
+	}
+	doc, _ = docs.DocumentForIdentifier("2")
+	if doc.Content.Text != "fix the tests #work" || len(doc.History) != 1 {
+		t.Errorf("unexpected updated document (%+v)", doc)
+	}
+}
+
+func TestConvertLeavesSource(t *testing.T) {
+	docs, err := documents.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.logger")
+	if _, err := ConvertToDocuments(missing, docs); err == nil || err.(Error).Code != "NotFound" {
+		t.Errorf("expected NotFound converting a missing database (%v)", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("expected missing database not to be created (%v)", err)
+	}
+
+	// Older databases are converted without being migrated or backed up.
+	name := openFixture(t, "v2.sql")
+	conversion, err := ConvertToDocuments(name, docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conversion.Converted != 2 {
+		t.Errorf("unexpected conversion (%+v)", conversion)
+	}
+	conn, err := sqlx.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if version, err := migrate.Version(conn); err != nil || version != 2 {
+		t.Errorf("expected source to be left at version 2 (%d, %v)", version, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(name), "backups")); !os.IsNotExist(err) {
+		t.Errorf("expected source not to be backed up (%v)", err)
+	}
+}
@@ -0,0 +1,129 @@
+package production
+
+import (
+	"time"
+)
+
+// dateKeys are the tag keys whose values are dates, e.g. #due=2021-04-01 or
+// #remind=2021-04-01T09:00. Dates without a time are due by the end of the
+// day. Values are in local time.
+var dateKeys = map[string]bool{
+	"due":    true,
+	"remind": true,
+}
+
+// dateLayouts are the accepted formats of date tag values.
+var dateLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	dateLayout,
+}
+
+// date is a parsed date tag.
+type date struct {
+	Key    string `json:"key" db:"key"`
+	At     int64  `json:"at" db:"at"` // start of the day for all-day dates
+	AllDay bool   `json:"allDay" db:"all_day"`
+}
+
+// Overdue returns entries with a date that has passed, earliest first.
+// All-day dates pass at the end of their day.
+func (m *manager) Overdue() []byte {
+	now := time.Now()
+	return m.entriesDated(`(all_day = 1 AND at < $1) OR (all_day = 0 AND at < $2)`, startOfDay(now, 0), now.Unix())
+}
+
+// DueToday returns entries with a date later today that isn't overdue,
+// earliest first.
+func (m *manager) DueToday() []byte {
+	now := time.Now()
+	return m.entriesDated(`at >= $1 AND at < $2 AND (all_day = 1 OR at >= $3)`, startOfDay(now, 0), startOfDay(now, 1), now.Unix())
+}
+
+// Upcoming returns entries with a date within the given number of days after
+// today, earliest first.
+func (m *manager) Upcoming(days int64) []byte {
+	if days < 1 {
+		days = 1
+	}
+	now := time.Now()
+	return m.entriesDated(`at >= $1 AND at < $2`, startOfDay(now, 1), startOfDay(now, int(days)+1))
+}
+
+// entriesDated returns entries in the current entries having a date matching
+// the given condition on entry_date, ordered by their earliest such date.
+func (m *manager) entriesDated(cond string, args ...interface{}) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var entries []entry
+	if err := m.db.Select(&entries, `
+		SELECT entry.* FROM entry
+		JOIN (SELECT entry_id, min(at) AS at FROM entry_date WHERE `+cond+` GROUP BY entry_id) AS dated
+			ON dated.entry_id = entry.id
+		WHERE entry.deleted = 0
+		ORDER BY dated.at, entry.id`, args...); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get dated entries: %s", err.Error()))
+	}
+	if err := m.loadDetails(entries); err != nil {
+		return encodeError(wrapError(err, "failed to get entry details"))
+	}
+	return encodeResponse(encodeSnapshot(entries, ""))
+}
+
+// startOfDay returns the start of the local day the given number of days
+// after t.
+func startOfDay(t time.Time, days int) int64 {
+	y, mo, d := t.Date()
+	return time.Date(y, mo, d+days, 0, 0, 0, 0, t.Location()).Unix()
+}
+
+// indexEntryDates replaces the stored dates of an entry with those parsed
+// from its text.
+func indexEntryDates(tx *txn, id int64, text string) error {
+	if _, err := tx.Exec(`DELETE FROM entry_date WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	for _, d := range encodeEntryDates(text) {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO entry_date (entry_id, key, at, all_day) VALUES ($1, $2, $3, $4)`,
+			id, d.Key, d.At, d.AllDay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillDates indexes the dates of every entry, used when upgrading a
+// database created before dates were indexed.
+func backfillDates(tx *txn) error {
+	var entries []entry
+	if err := tx.Select(&entries, `SELECT id, text FROM entry`); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := indexEntryDates(tx, entry.ID, entry.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeEntryDates returns the date tags in the given text, ignoring values
+// that aren't valid dates.
+func encodeEntryDates(text string) []date {
+	dates := []date{}
+	for _, tag := range encodeEntryTags(text) {
+		if !dateKeys[tag.Key] {
+			continue
+		}
+		for _, layout := range dateLayouts {
+			t, err := time.ParseInLocation(layout, tag.Value, time.Local)
+			if err != nil {
+				continue
+			}
+			dates = append(dates, date{Key: tag.Key, At: t.Unix(), AllDay: layout == dateLayout})
+			break
+		}
+	}
+	return dates
+}
@@ -0,0 +1,59 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDates(t *testing.T) {
+	db := New(":memory:")
+	now := time.Now()
+	day := func(days int) string {
+		return now.AddDate(0, 0, days).Format(dateLayout)
+	}
+	db.EntryCreate("pay rent #due="+day(-2), 0)
+	db.EntryCreate("call mom #remind="+now.Add(-time.Minute).Format("2006-01-02T15:04"), 0)
+	db.EntryCreate("file taxes #due="+day(0), 0)
+	db.EntryCreate("dentist #remind="+day(3)+"T09:00", 0)
+	db.EntryCreate("vacation #due="+day(30), 0)
+	db.EntryCreate("not a date #due=soon", 0)
+
+	var s snapshot
+	if err := json.Unmarshal(db.Overdue(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 2 || ids[0] != 1 {
+		t.Errorf("unexpected overdue entries (%v)", ids)
+	}
+	if err := json.Unmarshal(db.DueToday(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 3 {
+		t.Errorf("unexpected entries due today (%v)", ids)
+	}
+	if err := json.Unmarshal(db.Upcoming(7), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 4 {
+		t.Fatalf("unexpected upcoming entries (%v)", ids)
+	}
+	if dates := s.Entries[0].Dates; len(dates) != 1 || dates[0].Key != "remind" || dates[0].AllDay {
+		t.Errorf("unexpected dates (%+v)", dates)
+	}
+
+	db.EntryUpdate(4, "dentist moved #remind="+day(10)+"T09:00", 0)
+	if err := json.Unmarshal(db.Upcoming(7), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 0 {
+		t.Errorf("expected rescheduled entry to move (%v)", entryIDs(s.Entries))
+	}
+	db.EntryDelete(1)
+	if err := json.Unmarshal(db.Overdue(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("trashed entry listed as overdue (%v)", ids)
+	}
+}
@@ -0,0 +1,393 @@
+package production
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nathanborror/logger/pkg/seal"
+)
+
+// Encryption
+//
+// Encrypted databases seal the text of entries, revisions, journaled states
+// and attachment data under a data key kept in a keyring sealed with the
+// user's passphrase. Everything derived from entry text, the full-text index
+// along with the tag, date, link and reference indexes, is only kept in
+// memory while the database is unlocked. SQLite resolves unqualified table
+// names to the temp schema first so the rest of the package uses the
+// in-memory tables without knowing about them.
+
+// derivedTables hold data derived from entry text.
+var derivedTables = []string{"entry_index", "tag", "entry_tag", "entry_date", "entry_link", "entry_reference"}
+
+// indexTriggers keep the full-text index in step with unencrypted entries,
+// matching the triggers created by migrations 1 and 2.
+const indexTriggers = `
+	CREATE TRIGGER IF NOT EXISTS after_entry_insert AFTER INSERT ON entry BEGIN
+		INSERT INTO entry_index (rowid, text) VALUES (new.id, new.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS after_entry_update AFTER UPDATE OF text ON entry BEGIN
+		UPDATE entry_index SET text = new.text WHERE rowid = old.id;
+	END;
+	CREATE TRIGGER IF NOT EXISTS after_entry_delete AFTER DELETE ON entry BEGIN
+		DELETE FROM entry_index WHERE rowid = old.id;
+	END;
+`
+
+type encryption struct {
+	Encrypted bool   `json:"encrypted"`
+	Locked    bool   `json:"locked"`
+	Error     *Error `json:"error"`
+}
+
+// EncryptionState reports whether the database is encrypted and locked.
+func (m *manager) EncryptionState() []byte {
+	return encodeResponse(encryption{Encrypted: m.keyring != nil, Locked: m.keyring != nil && m.box == nil})
+}
+
+// SetPassphrase encrypts the database with the given passphrase. When it's
+// already encrypted the current passphrase is required and the content is
+// resealed under a new data key, so changing the passphrase also rotates the
+// key. An empty passphrase decrypts the database. Encrypting the database
+// replaces its automatic backups with one that's encrypted too.
+func (m *manager) SetPassphrase(current string, passphrase string) []byte {
+	var (
+		from *seal.Box
+		err  error
+	)
+	if m.keyring != nil {
+		if from, err = m.keyring.Unlock(current); err == seal.ErrPassphrase {
+			return encodeError(ErrorInvalidPassphrase("incorrect passphrase"))
+		} else if err != nil {
+			return encodeError(ErrorProgrammerFailure("failed to unlock: %s", err.Error()))
+		}
+	} else if passphrase == "" {
+		return m.CurrentPage("", pageLimit)
+	}
+
+	var (
+		keyring *seal.Keyring
+		to      *seal.Box
+	)
+	if passphrase != "" {
+		if keyring, to, err = seal.NewKeyring(passphrase); err != nil {
+			return encodeError(ErrorProgrammerFailure("failed to create key: %s", err.Error()))
+		}
+	}
+	err = m.run(func(tx *txn) error {
+		if from == nil {
+			// Drop the plain text indexes, they're rebuilt in memory.
+			if _, err := tx.Exec(`DROP TRIGGER after_entry_insert; DROP TRIGGER after_entry_update; DROP TRIGGER after_entry_delete`); err != nil {
+				return err
+			}
+			for _, table := range derivedTables {
+				if _, err := tx.Exec(`DELETE FROM main.` + table); err != nil {
+					return err
+				}
+			}
+			// Deleting from the full-text index only records tombstones, the
+			// tokens stay in its shadow tables until the segments are merged.
+			if _, err := tx.Exec(`INSERT INTO main.entry_index (entry_index) VALUES ('optimize')`); err != nil {
+				return err
+			}
+		}
+		if to == nil {
+			if err := dropIndex(tx); err != nil {
+				return err
+			}
+		}
+		if err := reseal(tx, from, to); err != nil {
+			return err
+		}
+		if to == nil {
+			tx.box = nil
+			return restoreIndex(tx)
+		}
+		data, err := json.Marshal(keyring)
+		if err != nil {
+			return err
+		}
+		return setSetting(tx, settingEncryption, string(data))
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to set passphrase"))
+	}
+	m.keyring, m.box = keyring, to
+
+	// Rewrite the database file so no plain text remains in free pages. This
+	// happens before the in-memory indexes are built, VACUUM fails when temp
+	// tables share names with those in main.
+	if from == nil {
+		if _, err := m.db.Exec(`VACUUM`); err != nil {
+			return encodeError(ErrorProgrammerFailure("failed to vacuum: %s", err.Error()))
+		}
+		if dir := m.backupDir(); dir != "" {
+			if err := m.replaceBackups(dir); err != nil {
+				return encodeError(ErrorProgrammerFailure("failed to replace backups: %s", err.Error()))
+			}
+		}
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// Unlock opens an encrypted database with the given passphrase, building the
+// indexes needed to search it.
+func (m *manager) Unlock(passphrase string) []byte {
+	if m.keyring == nil {
+		return encodeError(ErrorNotEncrypted("database isn't encrypted"))
+	}
+	box, err := m.keyring.Unlock(passphrase)
+	if err == seal.ErrPassphrase {
+		return encodeError(ErrorInvalidPassphrase("incorrect passphrase"))
+	} else if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to unlock: %s", err.Error()))
+	}
+	m.box = box
+	if err := m.run(buildIndex); err != nil {
+		m.box = nil
+		return encodeError(wrapError(err, "failed to unlock"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// Lock forgets the key of an encrypted database and discards its in-memory
+// indexes.
+func (m *manager) Lock() []byte {
+	if m.keyring == nil {
+		return encodeError(ErrorNotEncrypted("database isn't encrypted"))
+	}
+	m.box = nil
+	if err := m.run(dropIndex); err != nil {
+		return encodeError(wrapError(err, "failed to lock"))
+	}
+	return m.EncryptionState()
+}
+
+// unlocked returns an error when the database is encrypted and locked. The
+// in-memory indexes belong to the database connection so they're rebuilt if
+// the connection has been replaced.
+func (m *manager) unlocked() error {
+	if m.keyring == nil {
+		return nil
+	}
+	if m.box == nil {
+		return ErrorLocked("database is locked")
+	}
+	var n int
+	if err := m.db.Get(&n, `SELECT count(*) FROM sqlite_temp_master WHERE name = 'entry_index'`); err != nil {
+		return err
+	}
+	if n == 0 {
+		return m.run(buildIndex)
+	}
+	return nil
+}
+
+// buildIndex creates the derived tables in memory and indexes every entry.
+func buildIndex(tx *txn) error {
+	if err := dropIndex(tx); err != nil {
+		return err
+	}
+	query, args, err := sqlx.In(`
+		SELECT sql FROM main.sqlite_master
+		WHERE tbl_name IN (?) AND type IN ('table', 'index') AND sql IS NOT NULL
+		ORDER BY type = 'index'`, derivedTables)
+	if err != nil {
+		return err
+	}
+	var schema []string
+	if err := tx.Select(&schema, query, args...); err != nil {
+		return err
+	}
+	replacer := strings.NewReplacer(
+		"CREATE TABLE ", "CREATE TEMP TABLE ",
+		"CREATE VIRTUAL TABLE ", "CREATE VIRTUAL TABLE temp.",
+		"CREATE INDEX ", "CREATE INDEX temp.",
+	)
+	for _, stmt := range schema {
+		if _, err := tx.Exec(replacer.Replace(stmt)); err != nil {
+			return err
+		}
+	}
+	var entries []entry
+	if err := tx.Select(&entries, `SELECT id, text FROM entry`); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		text, err := tx.open(e.Text)
+		if err != nil {
+			return err
+		}
+		if err := indexEntry(tx, e.ID, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropIndex discards the in-memory derived tables.
+func dropIndex(tx *txn) error {
+	for _, table := range derivedTables {
+		if _, err := tx.Exec(`DROP TABLE IF EXISTS temp.` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreIndex rebuilds the derived tables of a decrypted database.
+func restoreIndex(tx *txn) error {
+	if _, err := tx.Exec(indexTriggers); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO entry_index (rowid, text) SELECT id, text FROM entry`); err != nil {
+		return err
+	}
+	for _, backfill := range []func(*txn) error{backfillTags, backfillDates, backfillLinks, backfillReferences} {
+		if err := backfill(tx); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`DELETE FROM setting WHERE key = $1`, settingEncryption)
+	return err
+}
+
+// indexEntryText adds the text of an entry to the in-memory full-text index.
+func indexEntryText(tx *txn, id int64, text string) error {
+	if _, err := tx.Exec(`DELETE FROM entry_index WHERE rowid = $1`, id); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO entry_index (rowid, text) VALUES ($1, $2)`, id, text)
+	return err
+}
+
+// reseal opens everything sealed with from and seals it with to, where a nil
+// box means plain text.
+func reseal(tx *txn, from, to *seal.Box) error {
+	convert := func(str string) (string, error) {
+		if from != nil {
+			var err error
+			if str, err = from.OpenString(str); err != nil {
+				return "", err
+			}
+		}
+		if to != nil {
+			str = to.SealString(str)
+		}
+		return str, nil
+	}
+	for _, table := range []string{"entry", "entry_revision"} {
+		var rows []struct {
+			ID   int64  `db:"id"`
+			Text string `db:"text"`
+		}
+		if err := tx.Select(&rows, `SELECT id, text FROM `+table); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			text, err := convert(row.Text)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`UPDATE `+table+` SET text = $1 WHERE id = $2`, text, row.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	var ops []operation
+	if err := tx.Select(&ops, `SELECT * FROM journal`); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		for _, state := range []*sql.NullString{&op.Before, &op.After} {
+			if !state.Valid {
+				continue
+			}
+			str, err := convert(state.String)
+			if err != nil {
+				return err
+			}
+			state.String = str
+		}
+		if _, err := tx.Exec(`UPDATE journal SET before = $1, after = $2 WHERE id = $3`, op.Before, op.After, op.ID); err != nil {
+			return err
+		}
+	}
+
+	var attachments []struct {
+		Hash string `db:"hash"`
+		Data []byte `db:"data"`
+	}
+	if err := tx.Select(&attachments, `SELECT hash, data FROM attachment`); err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		data := a.Data
+		if from != nil {
+			var err error
+			if data, err = from.Open(data); err != nil {
+				return err
+			}
+		}
+		if to != nil {
+			data = to.Seal(data)
+		}
+		if _, err := tx.Exec(`UPDATE attachment SET data = $1 WHERE hash = $2`, data, a.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadKeyring returns the keyring of an encrypted database or nil.
+func loadKeyring(db *sqlx.DB) (*seal.Keyring, error) {
+	var value string
+	if err := db.Get(&value, `SELECT value FROM setting WHERE key = $1`, settingEncryption); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var keyring seal.Keyring
+	if err := json.Unmarshal([]byte(value), &keyring); err != nil {
+		return nil, err
+	}
+	return &keyring, nil
+}
+
+func (tx *txn) seal(text string) string {
+	if tx.box == nil {
+		return text
+	}
+	return tx.box.SealString(text)
+}
+
+func (tx *txn) sealBytes(data []byte) []byte {
+	if tx.box == nil {
+		return data
+	}
+	return tx.box.Seal(data)
+}
+
+func (tx *txn) open(text string) (string, error) {
+	if tx.box == nil {
+		return text, nil
+	}
+	return tx.box.OpenString(text)
+}
+
+func (m *manager) open(text string) (string, error) {
+	if m.box == nil {
+		return text, nil
+	}
+	return m.box.OpenString(text)
+}
+
+func (m *manager) openBytes(data []byte) ([]byte, error) {
+	if m.box == nil {
+		return data, nil
+	}
+	return m.box.Open(data)
+}
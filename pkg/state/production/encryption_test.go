@@ -0,0 +1,188 @@
+package production
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data.logger")
+	db := New(name)
+	m := db.(*manager)
+	db.EntryCreate("secret plans #work", 0)
+	db.EntryUpdate(1, "secret plans for monday #work", 0)
+	db.EntryCreate("grocery list", 0)
+	db.EntryAttach(2, []byte("attached secret"), "text/plain")
+	backups := filepath.Join(filepath.Dir(name), "backups")
+	if made, err := m.autoBackup(backups, 0); err != nil || !made {
+		t.Fatalf("failed to back up (%v, %v)", made, err)
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(db.SetPassphrase("", "hunter2"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil || len(s.Entries) != 2 {
+		t.Fatalf("unexpected snapshot after enabling (%+v)", s)
+	}
+
+	// Nothing readable is left in the database file.
+	var stored []string
+	for _, query := range []string{
+		`SELECT text FROM entry`,
+		`SELECT text FROM entry_revision`,
+		`SELECT after FROM journal WHERE after IS NOT NULL`,
+		`SELECT CAST(data AS TEXT) FROM attachment`,
+		`SELECT id FROM main.tag`,
+		`SELECT text FROM main.entry_index`,
+	} {
+		var values []string
+		m.db.Select(&values, query)
+		stored = append(stored, values...)
+	}
+	for _, value := range stored {
+		if strings.Contains(value, "secret") || strings.Contains(value, "grocery") || strings.Contains(value, "work") {
+			t.Errorf("unexpected plain text stored (%q)", value)
+		}
+	}
+
+	// Nor in the database file or its automatic backups, which are replaced.
+	paths, _ := filepath.Glob(filepath.Join(backups, "*"))
+	if len(paths) != 1 {
+		t.Errorf("expected plain text backups to be replaced (%v)", paths)
+	}
+	for _, path := range append(paths, name) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, word := range []string{"secret", "grocery", "monday", "attached"} {
+			if bytes.Contains(data, []byte(word)) {
+				t.Errorf("unexpected plain text in %s (%q)", filepath.Base(path), word)
+			}
+		}
+	}
+
+	// Search, tags and attachments work while unlocked.
+	if err := json.Unmarshal(db.EntrySearch("monday"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("unexpected search results (%v)", ids)
+	}
+	if err := json.Unmarshal(db.EntrySearch("tag:work"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 1 || s.Entries[0].Text != "secret plans for monday" {
+		t.Errorf("unexpected tag results (%+v)", s.Entries)
+	}
+	db.EntryCreate("another #work entry", 0)
+	if err := json.Unmarshal(db.EntrySearch("another"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 3 {
+		t.Errorf("new entry not searchable (%v)", ids)
+	}
+
+	// Everything fails while locked.
+	var state encryption
+	if err := json.Unmarshal(db.Lock(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Encrypted || !state.Locked {
+		t.Errorf("unexpected state after locking (%+v)", state)
+	}
+	for name, data := range map[string][]byte{
+		"CurrentPage":      db.CurrentPage("", 10),
+		"EntrySearch":      db.EntrySearch("secret"),
+		"EntryCreate":      db.EntryCreate("locked", 0),
+		"Tags":             db.Tags(""),
+		"Overdue":          db.Overdue(),
+		"EntriesLinkingTo": db.EntriesLinkingTo("example.com"),
+	} {
+		var s snapshot
+		if err := json.Unmarshal(data, &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error == nil || s.Error.Code != "Locked" {
+			t.Errorf("expected %s to fail with Locked (%+v)", name, s.Error)
+		}
+	}
+	if err := json.Unmarshal(db.Unlock("wrong"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "InvalidPassphrase" {
+		t.Errorf("expected InvalidPassphrase error (%+v)", s.Error)
+	}
+	if err := json.Unmarshal(db.Unlock("hunter2"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil || len(s.Entries) != 3 {
+		t.Fatalf("unexpected snapshot after unlocking (%+v)", s)
+	}
+
+	// Changing the passphrase requires the current one and rotates the key.
+	var before string
+	m.db.Get(&before, `SELECT text FROM entry WHERE id = 1`)
+	if err := json.Unmarshal(db.SetPassphrase("wrong", "swordfish"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "InvalidPassphrase" {
+		t.Errorf("expected InvalidPassphrase error (%+v)", s.Error)
+	}
+	if err := json.Unmarshal(db.SetPassphrase("hunter2", "swordfish"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil || len(s.Entries) != 3 {
+		t.Fatalf("unexpected snapshot after changing passphrase (%+v)", s)
+	}
+	var after string
+	m.db.Get(&after, `SELECT text FROM entry WHERE id = 1`)
+	if after == before {
+		t.Errorf("expected entry to be resealed")
+	}
+	db.Lock()
+	if err := json.Unmarshal(db.Unlock("hunter2"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "InvalidPassphrase" {
+		t.Errorf("expected old passphrase to fail (%+v)", s.Error)
+	}
+	db.Unlock("swordfish")
+
+	// Removing the passphrase restores plain text and the indexes.
+	if err := json.Unmarshal(db.SetPassphrase("swordfish", ""), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil || len(s.Entries) != 3 {
+		t.Fatalf("unexpected snapshot after disabling (%+v)", s)
+	}
+	var text string
+	m.db.Get(&text, `SELECT text FROM entry WHERE id = 1`)
+	if text != "secret plans for monday #work" {
+		t.Errorf("unexpected stored text (%q)", text)
+	}
+	db.EntryCreate("plain monday", 0)
+	if err := json.Unmarshal(db.EntrySearch("monday"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 2 || ids[0] != 4 || ids[1] != 1 {
+		t.Errorf("unexpected search results after disabling (%v)", ids)
+	}
+	if err := json.Unmarshal(db.Undo(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil || len(s.Entries) != 3 {
+		t.Errorf("unexpected snapshot after undo (%+v)", s)
+	}
+	if err := json.Unmarshal(db.EncryptionState(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Encrypted || state.Locked {
+		t.Errorf("unexpected state after disabling (%+v)", state)
+	}
+}
@@ -0,0 +1,150 @@
+package production
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Markers passed to the FTS5 highlight() and snippet() functions. They're
+// taken from the private use area so they never collide with entry text.
+const (
+	markOpen  = "\ue000"
+	markClose = "\ue001"
+)
+
+// snippetTokens is the maximum number of tokens in a snippet.
+const snippetTokens = 16
+
+// match is a range of matched text. Offsets are in UTF-16 code units so they
+// can be used directly as an NSRange by the client.
+type match struct {
+	Start  int `json:"start"`
+	Length int `json:"length"`
+}
+
+// snippet is a short excerpt of an entry surrounding the matched terms.
+type snippet struct {
+	Text    string  `json:"text"`
+	Matches []match `json:"matches"`
+}
+
+// decodeHighlight removes the markers from highlighted entry text, cleaning
+// it like encodeEntryText, and returns the ranges of the marked terms.
+func decodeHighlight(marked string) (string, []match) {
+	var (
+		raw    []rune
+		ranges [][2]int
+		start  int
+	)
+	for _, r := range marked {
+		switch string(r) {
+		case markOpen:
+			start = len(raw)
+		case markClose:
+			ranges = append(ranges, [2]int{start, len(raw)})
+		default:
+			raw = append(raw, r)
+		}
+	}
+	text, offsets := cleanText(string(raw))
+	cleaned := []rune(text)
+	matches := []match{}
+	for _, rng := range ranges {
+		if rng[0] >= rng[1] {
+			continue
+		}
+		first, last := offsets[rng[0]], offsets[rng[1]-1]
+		if first < 0 || last < 0 {
+			continue // matched inside a hashtag which is removed from the text
+		}
+		matches = append(matches, match{
+			Start:  utf16Len(cleaned[:first]),
+			Length: utf16Len(cleaned[first : last+1]),
+		})
+	}
+	return text, matches
+}
+
+// cleanText removes hashtags, collapses runs of whitespace and trims the
+// given text. It returns the cleaned text along with the rune offset in the
+// cleaned text of each rune in the original, or -1 when the rune was removed.
+func cleanText(text string) (string, []int) {
+	runes := []rune(text)
+	tagged := make([]bool, len(runes))
+	for _, loc := range reHashTag.FindAllStringIndex(text, -1) {
+		start := len([]rune(text[:loc[0]]))
+		end := start + len([]rune(text[loc[0]:loc[1]]))
+		for i := start; i < end; i++ {
+			tagged[i] = true
+		}
+	}
+
+	// Replace each hashtag with a single space.
+	type char struct {
+		r   rune
+		src int // -1 when the char stands in for a hashtag
+	}
+	var chars []char
+	for i, r := range runes {
+		if tagged[i] {
+			if i == 0 || !tagged[i-1] {
+				chars = append(chars, char{' ', -1})
+			}
+			continue
+		}
+		chars = append(chars, char{r, i})
+	}
+
+	// Collapse runs of two or more whitespace characters into a single space.
+	var collapsed []char
+	for i := 0; i < len(chars); {
+		j := i
+		for j < len(chars) && isSpace(chars[j].r) {
+			j++
+		}
+		if j-i >= 2 {
+			collapsed = append(collapsed, char{' ', -1})
+			i = j
+			continue
+		}
+		collapsed = append(collapsed, chars[i])
+		i++
+	}
+
+	// Trim leading and trailing whitespace.
+	start, end := 0, len(collapsed)
+	for start < end && unicode.IsSpace(collapsed[start].r) {
+		start++
+	}
+	for end > start && unicode.IsSpace(collapsed[end-1].r) {
+		end--
+	}
+	collapsed = collapsed[start:end]
+
+	offsets := make([]int, len(runes))
+	for i := range offsets {
+		offsets[i] = -1
+	}
+	var b strings.Builder
+	for i, c := range collapsed {
+		b.WriteRune(c.r)
+		if c.src >= 0 {
+			offsets[c.src] = i
+		}
+	}
+	return b.String(), offsets
+}
+
+// isSpace matches the ASCII whitespace class (\s) of regular expressions.
+func isSpace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+func utf16Len(runes []rune) int {
+	return len(utf16.Encode(runes))
+}
@@ -0,0 +1,52 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEntrySearchHighlight(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("#errands  buy milk and eggs 🥚 then more milk", 0)
+
+	s := snapshot{}
+	if err := json.Unmarshal(db.EntrySearch("milk"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 1 {
+		t.Fatalf("entries != 1 (%d)", len(s.Entries))
+	}
+	entry := s.Entries[0]
+	if entry.Text != "buy milk and eggs 🥚 then more milk" {
+		t.Errorf("unexpected text (%q)", entry.Text)
+	}
+	expected := []match{{Start: 4, Length: 4}, {Start: 31, Length: 4}}
+	if len(entry.Matches) != len(expected) {
+		t.Fatalf("unexpected matches (%+v)", entry.Matches)
+	}
+	for i, m := range expected {
+		if entry.Matches[i] != m {
+			t.Errorf("match %d = %+v, expected %+v", i, entry.Matches[i], m)
+		}
+	}
+	if entry.Snippet == nil || len(entry.Snippet.Matches) != 2 {
+		t.Errorf("missing snippet matches (%+v)", entry.Snippet)
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	for text, expected := range map[string]string{
+		"foo #bar baz":              "foo baz",
+		"  #foo\n\nbar\tbaz #qux  ": "bar\tbaz",
+		"foo\nbar":                  "foo\nbar",
+		"#only":                     "",
+	} {
+		cleaned, offsets := cleanText(text)
+		if cleaned != expected {
+			t.Errorf("%q: cleaned %q, expected %q", text, cleaned, expected)
+		}
+		if len(offsets) != len([]rune(text)) {
+			t.Errorf("%q: offsets != %d", text, len([]rune(text)))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package production
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// record is an entry to import. Ref identifies the record in its source,
+// such as a row number, and is used when reporting failures.
+type record struct {
+	Ref      string `json:"ref"`
+	Text     string `json:"text"`
+	Color    int64  `json:"color"`
+	Created  int64  `json:"created"`
+	Modified int64  `json:"modified"`
+}
+
+// Import summarizes importing records.
+type Import struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"` // records matching an existing entry
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors"`
+	Error    *Error   `json:"error"`
+}
+
+// EntriesImport creates entries from a JSON array of records, keeping their
+// timestamps. Records with the same text, ignoring hashtags and whitespace,
+// and created time as an existing entry, including one in the trash, are
+// skipped so importing the same data again does nothing. Imports are
+// all-or-nothing per call but aren't journaled and can't be undone.
+func (m *manager) EntriesImport(data []byte) []byte {
+	var records []record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to decode records: %s", err.Error()))
+	}
+	summary := Import{Errors: []string{}}
+	err := m.transact(func(tx *txn) error {
+		for i, r := range records {
+			ref := r.Ref
+			if ref == "" {
+				ref = fmt.Sprintf("record %d", i+1)
+			}
+			if strings.TrimSpace(r.Text) == "" || r.Created <= 0 {
+				summary.Failed++
+				summary.Errors = append(summary.Errors, ref+": missing text or created time")
+				continue
+			}
+			exists, err := entryExists(tx, r.Text, r.Created)
+			if err != nil {
+				return err
+			}
+			if exists {
+				summary.Skipped++
+				continue
+			}
+			if r.Modified < r.Created {
+				r.Modified = r.Created
+			}
+			if err := importRecord(tx, r); err != nil {
+				return err
+			}
+			summary.Imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to import"))
+	}
+	return encodeResponse(summary)
+}
+
+func importRecord(tx *txn, r record) error {
+	entry := entry{Text: tx.seal(r.Text), Color: r.Color, Created: r.Created, Modified: r.Modified}
+	res, err := tx.NamedExec(`INSERT INTO entry (text, color, created, modified) VALUES (:text, :color, :created, :modified)`, entry)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := insertRevision(tx, id, r.Text, r.Color, r.Modified, 0); err != nil {
+		return err
+	}
+	return indexEntry(tx, id, r.Text)
+}
+
+// entryExists reports whether an entry created at the given time has the
+// same text once cleaned, so exported entries match their originals.
+func entryExists(tx *txn, text string, created int64) (bool, error) {
+	var texts []string
+	if err := tx.Select(&texts, `SELECT text FROM entry WHERE created = $1`, created); err != nil {
+		return false, err
+	}
+	cleaned := encodeEntryText(text)
+	for _, t := range texts {
+		t, err := tx.open(t)
+		if err != nil {
+			return false, err
+		}
+		if encodeEntryText(t) == cleaned {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,236 @@
+package production
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Journaled mutation kinds.
+const (
+	journalCreate  = "create"
+	journalUpdate  = "update"
+	journalDelete  = "delete"
+	journalRevert  = "revert"
+	journalRestore = "restore"
+	journalPin     = "pin"
+	journalUnpin   = "unpin"
+	journalToggle  = "toggle"
+)
+
+// defaultUndoDepth is the number of mutations that can be undone unless
+// configured otherwise.
+const defaultUndoDepth = 50
+
+// operation is a journaled mutation of an entry. Before and after hold the
+// entry as JSON, or NULL when it didn't exist.
+type operation struct {
+	ID      int64          `db:"id"`
+	Kind    string         `db:"kind"`
+	EntryID int64          `db:"entry_id"`
+	Before  sql.NullString `db:"before"`
+	After   sql.NullString `db:"after"`
+	Undone  bool           `db:"undone"`
+	Created int64          `db:"created"`
+}
+
+// Undo reverses the most recent mutation that hasn't been undone.
+func (m *manager) Undo() []byte {
+	err := m.transact(func(tx *txn) error {
+		var op operation
+		if err := tx.Get(&op, `SELECT * FROM journal WHERE undone = 0 ORDER BY id DESC LIMIT 1`); err == sql.ErrNoRows {
+			return ErrorEmptyJournal("nothing to undo")
+		} else if err != nil {
+			return err
+		}
+		if err := applyEntry(tx, op.EntryID, op.Before); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE journal SET undone = 1 WHERE id = $1`, op.ID)
+		return err
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to undo"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// Redo reapplies the most recently undone mutation. Redo is only possible
+// until the next mutation.
+func (m *manager) Redo() []byte {
+	err := m.transact(func(tx *txn) error {
+		var op operation
+		if err := tx.Get(&op, `SELECT * FROM journal WHERE undone = 1 ORDER BY id ASC LIMIT 1`); err == sql.ErrNoRows {
+			return ErrorEmptyJournal("nothing to redo")
+		} else if err != nil {
+			return err
+		}
+		if err := applyEntry(tx, op.EntryID, op.After); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE journal SET undone = 0 WHERE id = $1`, op.ID)
+		return err
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to redo"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// SetUndoDepth sets the number of mutations that can be undone, discarding
+// older ones. A depth of zero disables undo.
+func (m *manager) SetUndoDepth(depth int64) []byte {
+	if depth < 0 {
+		depth = 0
+	}
+	err := m.transact(func(tx *txn) error {
+		if err := setSetting(tx, settingUndoDepth, strconv.FormatInt(depth, 10)); err != nil {
+			return err
+		}
+		return trimJournal(tx)
+	})
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to set undo depth: %s", err.Error()))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// journaled runs fn, which mutates the given entry, and journals the change.
+func journaled(tx *txn, kind string, id int64, fn func() error) error {
+	before, err := loadEntry(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return journal(tx, kind, id, before)
+}
+
+// journal records a mutation of an entry, given its state beforehand, so it
+// can be undone. Recording a mutation discards anything that could be redone.
+func journal(tx *txn, kind string, id int64, before *entry) error {
+	after, err := loadEntry(tx, id)
+	if err != nil {
+		return err
+	}
+	beforeState, err := encodeState(tx, before)
+	if err != nil {
+		return err
+	}
+	afterState, err := encodeState(tx, after)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM journal WHERE undone = 1`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO journal (kind, entry_id, before, after, created) VALUES ($1, $2, $3, $4, $5)`,
+		kind, id, beforeState, afterState, time.Now().Unix()); err != nil {
+		return err
+	}
+	return trimJournal(tx)
+}
+
+// trimJournal discards mutations beyond the undo depth.
+func trimJournal(tx *txn) error {
+	depth, err := settingInt(tx, settingUndoDepth, defaultUndoDepth)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM journal WHERE id NOT IN (SELECT id FROM journal ORDER BY id DESC LIMIT $1)`, depth)
+	return err
+}
+
+// loadEntry returns the stored entry with the given id or nil when it
+// doesn't exist.
+func loadEntry(tx *txn, id int64) (*entry, error) {
+	var e entry
+	if err := tx.Get(&e, `SELECT * FROM entry WHERE id = $1`, id); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	text, err := tx.open(e.Text)
+	if err != nil {
+		return nil, err
+	}
+	e.Text = text
+	return &e, nil
+}
+
+// applyEntry returns an entry to a journaled state, removing it when the
+// state is NULL. Text and color changes are recorded as revisions.
+func applyEntry(tx *txn, id int64, state sql.NullString) error {
+	current, err := loadEntry(tx, id)
+	if err != nil {
+		return err
+	}
+	if !state.Valid {
+		if current == nil {
+			return nil
+		}
+		return removeEntry(tx, id)
+	}
+	data, err := tx.open(state.String)
+	if err != nil {
+		return err
+	}
+	var e entry
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if current == nil {
+		// The entry keeps its uid so peers see it return rather than a copy,
+		// with a version following its tombstone.
+		uid := sql.NullString{String: e.UID, Valid: e.UID != ""}
+		if uid.Valid {
+			dead, err := loadTombstone(tx, e.UID)
+			if err != nil {
+				return err
+			}
+			if dead != nil {
+				e.Version = e.Version.merge(dead.Version)
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO entry (id, uid, version, text, color, created, modified, deleted, pin_order, parent_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			id, uid, e.Version, tx.seal(e.Text), e.Color, e.Created, e.Modified, e.Deleted, e.PinOrder, e.ParentID); err != nil {
+			return err
+		}
+		if err := bumpVersion(tx, id); err != nil {
+			return err
+		}
+		if uid.Valid {
+			if err := adoptOrphans(tx, id, e.UID); err != nil {
+				return err
+			}
+		}
+		if err := insertRevision(tx, id, e.Text, e.Color, now, 0); err != nil {
+			return err
+		}
+		return indexEntry(tx, id, e.Text)
+	}
+	if _, err := tx.Exec(`UPDATE entry SET deleted = $1, pin_order = $2 WHERE id = $3`, e.Deleted, e.PinOrder, id); err != nil {
+		return err
+	}
+	if current.Text == e.Text && current.Color == e.Color {
+		if current.Deleted == e.Deleted {
+			return nil
+		}
+		return bumpVersion(tx, id)
+	}
+	return updateEntry(tx, id, e.Text, e.Color, 0)
+}
+
+func encodeState(tx *txn, e *entry) (sql.NullString, error) {
+	if e == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: tx.seal(string(data)), Valid: true}, nil
+}
@@ -0,0 +1,122 @@
+package production
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("first", 0)
+	db.EntryUpdate(1, "second", 1)
+	db.EntryDelete(1)
+
+	var s snapshot
+	for i, expected := range []string{"second", "first", ""} {
+		if err := json.Unmarshal(db.Undo(), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error != nil {
+			t.Fatalf("undo %d: %s", i, s.Error.Error())
+		}
+		if expected == "" {
+			if len(s.Entries) != 0 {
+				t.Errorf("undo %d: expected no entries (%+v)", i, s.Entries)
+			}
+			continue
+		}
+		if len(s.Entries) != 1 || s.Entries[0].Text != expected {
+			t.Errorf("undo %d: expected %q (%+v)", i, expected, s.Entries)
+		}
+	}
+	if err := json.Unmarshal(db.Undo(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "EmptyJournal" {
+		t.Errorf("expected EmptyJournal error (%+v)", s.Error)
+	}
+
+	for i, expected := range []string{"first", "second", ""} {
+		if err := json.Unmarshal(db.Redo(), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error != nil {
+			t.Fatalf("redo %d: %s", i, s.Error.Error())
+		}
+		if expected == "" {
+			if len(s.Entries) != 0 {
+				t.Errorf("redo %d: expected no entries (%+v)", i, s.Entries)
+			}
+			continue
+		}
+		if len(s.Entries) != 1 || s.Entries[0].Text != expected || s.Entries[0].ID != 1 {
+			t.Errorf("redo %d: expected %q (%+v)", i, expected, s.Entries)
+		}
+	}
+}
+
+func TestRedoKeepsUID(t *testing.T) {
+	db := New(":memory:")
+	var s snapshot
+	json.Unmarshal(db.EntryCreate("first", 0), &s)
+	created := s.Entries[0]
+	db.Undo()
+
+	var c changes
+	json.Unmarshal(db.ChangesSince("", 0), &c)
+	if len(c.Tombstones) != 1 || c.Tombstones[0].UID != created.UID {
+		t.Fatalf("expected tombstone for undone entry (%+v)", c.Tombstones)
+	}
+	var redone snapshot
+	json.Unmarshal(db.Redo(), &redone)
+	if e := redone.Entries[0]; e.UID != created.UID || e.Version.compare(created.Version) != clockAfter {
+		t.Errorf("expected entry to return with its uid and a newer version (%+v, %+v)", e, created)
+	}
+	json.Unmarshal(db.ChangesSince("", 0), &c)
+	if len(c.Tombstones) != 0 || len(c.Entries) != 1 {
+		t.Errorf("expected tombstone to be replaced (%+v)", c)
+	}
+}
+
+func TestUndoDiscardsRedo(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("first", 0)
+	db.EntryUpdate(1, "second", 0)
+	db.Undo()
+	db.EntryUpdate(1, "third", 0)
+
+	var s snapshot
+	if err := json.Unmarshal(db.Redo(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "EmptyJournal" {
+		t.Errorf("expected EmptyJournal error (%+v)", s.Error)
+	}
+}
+
+func TestUndoDepth(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data.logger")
+	db := New(name)
+	db.SetUndoDepth(2)
+	db.EntryCreate("first", 0)
+	db.EntryUpdate(1, "second", 0)
+	db.EntryUpdate(1, "third", 0)
+
+	// The journal survives reopening the database.
+	db = New(name)
+	var s snapshot
+	db.Undo()
+	if err := json.Unmarshal(db.Undo(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil || s.Entries[0].Text != "first" {
+		t.Fatalf("unexpected undo (%+v)", s)
+	}
+	if err := json.Unmarshal(db.Undo(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "EmptyJournal" {
+		t.Errorf("expected EmptyJournal error beyond depth (%+v)", s.Error)
+	}
+}
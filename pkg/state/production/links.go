@@ -0,0 +1,161 @@
+package production
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var reURL = regexp.MustCompile(`\bhttps?://[^\s<>"]+`)
+
+// link is a URL found in entry text. Position is the order of the link within
+// the entry, counting from zero.
+type link struct {
+	EntryID  int64  `json:"entryId,omitempty" db:"entry_id"`
+	Position int64  `json:"position" db:"position"`
+	URL      string `json:"url" db:"url"`
+	Host     string `json:"host" db:"host"`
+}
+
+type linkList struct {
+	Links []link `json:"links"`
+	Error *Error `json:"error"`
+}
+
+// hostUsage is a host along with how often it's linked to.
+type hostUsage struct {
+	Host    string `json:"host" db:"host"`
+	Count   int64  `json:"count" db:"count"`     // number of links
+	Entries int64  `json:"entries" db:"entries"` // number of entries with links
+}
+
+type hostList struct {
+	Hosts []hostUsage `json:"hosts"`
+	Error *Error      `json:"error"`
+}
+
+// Links returns every link in the current entries, most recent entries first.
+func (m *manager) Links() []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var list []link
+	if err := m.db.Select(&list, `
+		SELECT entry_link.* FROM entry_link
+		JOIN entry ON entry.id = entry_link.entry_id AND entry.deleted = 0
+		ORDER BY entry.created DESC, entry.id DESC, entry_link.position`); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get links: %s", err.Error()))
+	}
+	if list == nil {
+		list = []link{}
+	}
+	return encodeResponse(linkList{Links: list})
+}
+
+// LinkHosts returns every linked host with the number of links to it, most
+// linked first.
+func (m *manager) LinkHosts() []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var hosts []hostUsage
+	if err := m.db.Select(&hosts, `
+		SELECT entry_link.host, count(*) AS count, count(DISTINCT entry_link.entry_id) AS entries
+		FROM entry_link
+		JOIN entry ON entry.id = entry_link.entry_id AND entry.deleted = 0
+		GROUP BY entry_link.host
+		ORDER BY count DESC, entry_link.host`); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get hosts: %s", err.Error()))
+	}
+	if hosts == nil {
+		hosts = []hostUsage{}
+	}
+	return encodeResponse(hostList{Hosts: hosts})
+}
+
+// EntriesLinkingTo returns the entries linking to the given host or any of
+// its subdomains, newest first.
+func (m *manager) EntriesLinkingTo(host string) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	host = normalizeHost(host)
+	var entries []entry
+	if err := m.db.Select(&entries, `
+		SELECT * FROM entry
+		WHERE deleted = 0 AND id IN (
+			SELECT entry_id FROM entry_link WHERE host = $1 OR host LIKE $2 ESCAPE '\'
+		)
+		ORDER BY created DESC, id DESC`, host, "%."+escapeLike(host)); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get entries: %s", err.Error()))
+	}
+	if err := m.loadDetails(entries); err != nil {
+		return encodeError(wrapError(err, "failed to get entry details"))
+	}
+	return encodeResponse(encodeSnapshot(entries, ""))
+}
+
+// indexEntryLinks replaces the indexed links of an entry with those found in
+// text.
+func indexEntryLinks(tx *txn, id int64, text string) error {
+	if _, err := tx.Exec(`DELETE FROM entry_link WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	for _, l := range encodeEntryLinks(text) {
+		if _, err := tx.Exec(`INSERT INTO entry_link (entry_id, position, url, host) VALUES ($1, $2, $3, $4)`,
+			id, l.Position, l.URL, l.Host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillLinks indexes the links of every entry, used when upgrading a
+// database created before links were indexed.
+func backfillLinks(tx *txn) error {
+	var entries []entry
+	if err := tx.Select(&entries, `SELECT id, text FROM entry`); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := indexEntryLinks(tx, entry.ID, entry.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeEntryLinks returns the links found in text. Trailing punctuation and
+// unbalanced closing brackets are assumed to belong to the surrounding text.
+func encodeEntryLinks(text string) []link {
+	links := []link{}
+	for _, str := range reURL.FindAllString(text, -1) {
+		str = trimURL(str)
+		u, err := url.Parse(str)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		links = append(links, link{Position: int64(len(links)), URL: str, Host: normalizeHost(u.Hostname())})
+	}
+	return links
+}
+
+func trimURL(str string) string {
+	for len(str) > 0 {
+		last := str[len(str)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'", last) >= 0:
+		case last == ')' && strings.Count(str, "(") < strings.Count(str, ")"):
+		case last == ']' && strings.Count(str, "[") < strings.Count(str, "]"):
+		default:
+			return str
+		}
+		str = str[:len(str)-1]
+	}
+	return str
+}
+
+// normalizeHost lowercases a host and removes any leading www.
+func normalizeHost(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
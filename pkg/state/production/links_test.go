@@ -0,0 +1,69 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeEntryLinks(t *testing.T) {
+	links := encodeEntryLinks("see https://go.dev/doc, (http://en.wikipedia.org/wiki/Go_(game)) and https://WWW.Example.com. not ftp://x.org")
+	expected := []link{
+		{Position: 0, URL: "https://go.dev/doc", Host: "go.dev"},
+		{Position: 1, URL: "http://en.wikipedia.org/wiki/Go_(game)", Host: "en.wikipedia.org"},
+		{Position: 2, URL: "https://WWW.Example.com", Host: "example.com"},
+	}
+	if len(links) != len(expected) {
+		t.Fatalf("unexpected links (%+v)", links)
+	}
+	for i, l := range links {
+		if l != expected[i] {
+			t.Errorf("link %d: expected %+v, got %+v", i, expected[i], l)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("read https://go.dev/blog and https://pkg.go.dev/sqlx", 0)
+	db.EntryCreate("https://example.com/a", 0)
+	db.EntryCreate("no links", 0)
+
+	var s snapshot
+	if err := json.Unmarshal(db.Current(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries[2].Links) != 2 || s.Entries[2].Links[1].Host != "pkg.go.dev" {
+		t.Errorf("unexpected entry links (%+v)", s.Entries[2].Links)
+	}
+
+	var list linkList
+	if err := json.Unmarshal(db.Links(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Links) != 3 || list.Links[0].EntryID != 2 {
+		t.Errorf("unexpected links (%+v)", list.Links)
+	}
+
+	var hosts hostList
+	if err := json.Unmarshal(db.LinkHosts(), &hosts); err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts.Hosts) != 3 || hosts.Hosts[0].Host != "example.com" || hosts.Hosts[0].Count != 1 {
+		t.Errorf("unexpected hosts (%+v)", hosts.Hosts)
+	}
+
+	if err := json.Unmarshal(db.EntriesLinkingTo("go.dev"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("unexpected entries linking to go.dev (%v)", ids)
+	}
+
+	db.EntryUpdate(1, "links removed", 0)
+	if err := json.Unmarshal(db.EntriesLinkingTo("go.dev"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 0 {
+		t.Errorf("expected no entries (%v)", entryIDs(s.Entries))
+	}
+}
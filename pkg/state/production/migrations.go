@@ -0,0 +1,329 @@
+package production
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/nathanborror/logger/pkg/migrate"
+)
+
+// migrations upgrade the schema in order, append new ones to the end and
+// never modify a migration once it has shipped.
+var migrations = []migrate.Migration{
+	// 1: Initial schema. Databases created before versioning already have
+	// these tables so everything must be conditional.
+	migrate.Exec(`
+		CREATE TABLE IF NOT EXISTS entry (
+			id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+			text text NOT NULL,
+			color integer NOT NULL,
+			created integer NOT NULL,
+			modified integer NOT NULL
+		);
+		CREATE VIRTUAL TABLE IF NOT EXISTS entry_index USING fts5(text, tokenize=porter);
+		CREATE TRIGGER IF NOT EXISTS after_entry_insert AFTER INSERT ON entry BEGIN
+			INSERT INTO entry_index (rowid, text) VALUES (new.id, new.text);
+		END;
+		CREATE TRIGGER IF NOT EXISTS after_entry_update AFTER UPDATE OF text ON entry BEGIN
+			UPDATE entry_index SET text = new.text WHERE rowid = old.id;
+		END;
+	`),
+
+	// 2: The delete trigger originally shared its name with the insert
+	// trigger so it was never created, leaving deleted entries indexed.
+	migrate.Exec(`
+		CREATE TRIGGER after_entry_delete AFTER DELETE ON entry BEGIN
+			DELETE FROM entry_index WHERE rowid = old.id;
+		END;
+		DELETE FROM entry_index WHERE rowid NOT IN (SELECT id FROM entry);
+	`),
+
+	// 3: Tag index.
+	func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE tag (
+				id text PRIMARY KEY NOT NULL,
+				namespace text NOT NULL,
+				key text NOT NULL,
+				value text NOT NULL
+			);
+			CREATE TABLE entry_tag (
+				entry_id integer NOT NULL,
+				position integer NOT NULL,
+				tag_id text NOT NULL,
+				PRIMARY KEY (entry_id, position)
+			);
+			CREATE INDEX entry_tag_tag_id ON entry_tag (tag_id);
+		`); err != nil {
+			return err
+		}
+		return backfillTags(&txn{Tx: tx})
+	},
+
+	// 4: Entry revisions, seeded with the current state of every entry.
+	migrate.Exec(`
+		CREATE TABLE entry_revision (
+			id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+			entry_id integer NOT NULL,
+			text text NOT NULL,
+			color integer NOT NULL,
+			created integer NOT NULL,
+			revert_of integer NOT NULL DEFAULT 0
+		);
+		CREATE INDEX entry_revision_entry_id ON entry_revision (entry_id);
+		INSERT INTO entry_revision (entry_id, text, color, created)
+			SELECT id, text, color, modified FROM entry ORDER BY id;
+	`),
+
+	// 5: Trash and settings.
+	migrate.Exec(`
+		ALTER TABLE entry ADD COLUMN deleted integer NOT NULL DEFAULT 0;
+		CREATE INDEX entry_deleted ON entry (deleted);
+		CREATE TABLE setting (
+			key text PRIMARY KEY NOT NULL,
+			value text NOT NULL
+		);
+	`),
+
+	// 6: Undo journal.
+	migrate.Exec(`
+		CREATE TABLE journal (
+			id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+			kind text NOT NULL,
+			entry_id integer NOT NULL,
+			before text,
+			after text,
+			undone integer NOT NULL DEFAULT 0,
+			created integer NOT NULL
+		);
+		CREATE INDEX journal_entry_id ON journal (entry_id);
+	`),
+
+	// 7: Pinned entries.
+	migrate.Exec(`
+		ALTER TABLE entry ADD COLUMN pin_order integer NOT NULL DEFAULT 0;
+	`),
+
+	// 8: Dates parsed from tags such as #due=2021-04-01. Tag values may now
+	// contain colons so tags are indexed again.
+	func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE entry_date (
+				entry_id integer NOT NULL,
+				key text NOT NULL,
+				at integer NOT NULL,
+				all_day integer NOT NULL,
+				PRIMARY KEY (entry_id, key, at)
+			);
+			CREATE INDEX entry_date_at ON entry_date (at);
+			DELETE FROM entry_tag;
+			DELETE FROM tag;
+		`); err != nil {
+			return err
+		}
+		if err := backfillTags(&txn{Tx: tx}); err != nil {
+			return err
+		}
+		return backfillDates(&txn{Tx: tx})
+	},
+
+	// 9: Attachments, stored once per distinct content.
+	migrate.Exec(`
+		CREATE TABLE attachment (
+			hash text PRIMARY KEY NOT NULL,
+			mime text NOT NULL,
+			size integer NOT NULL,
+			data blob NOT NULL,
+			created integer NOT NULL
+		);
+		CREATE TABLE entry_attachment (
+			entry_id integer NOT NULL,
+			hash text NOT NULL,
+			mime text NOT NULL,
+			created integer NOT NULL,
+			PRIMARY KEY (entry_id, hash)
+		);
+		CREATE INDEX entry_attachment_hash ON entry_attachment (hash);
+	`),
+
+	// 10: Links to web pages found in entry text.
+	func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE entry_link (
+				entry_id integer NOT NULL,
+				position integer NOT NULL,
+				url text NOT NULL,
+				host text NOT NULL,
+				PRIMARY KEY (entry_id, position)
+			);
+			CREATE INDEX entry_link_host ON entry_link (host);
+		`); err != nil {
+			return err
+		}
+		return backfillLinks(&txn{Tx: tx})
+	},
+
+	// 11: Links between entries.
+	func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE entry_reference (
+				entry_id integer NOT NULL,
+				position integer NOT NULL,
+				target text NOT NULL,
+				PRIMARY KEY (entry_id, position)
+			);
+			CREATE INDEX entry_reference_target ON entry_reference (target COLLATE NOCASE);
+		`); err != nil {
+			return err
+		}
+		return backfillReferences(&txn{Tx: tx})
+	},
+
+	// 12: Replies.
+	migrate.Exec(`
+		ALTER TABLE entry ADD COLUMN parent_id integer NOT NULL DEFAULT 0;
+		CREATE INDEX entry_parent_id ON entry (parent_id);
+	`),
+
+	// 13: Change feed.
+	migrate.Exec(`
+		CREATE TABLE entry_change (
+			seq integer PRIMARY KEY AUTOINCREMENT,
+			entry_id integer NOT NULL UNIQUE,
+			deleted integer NOT NULL DEFAULT 0
+		);
+		INSERT INTO entry_change (entry_id) SELECT id FROM entry ORDER BY modified, id;
+		CREATE TRIGGER IF NOT EXISTS after_entry_insert_change AFTER INSERT ON entry BEGIN
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted) VALUES (new.id, 0);
+		END;
+		CREATE TRIGGER IF NOT EXISTS after_entry_update_change AFTER UPDATE ON entry BEGIN
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted) VALUES (new.id, 0);
+		END;
+		CREATE TRIGGER IF NOT EXISTS after_entry_delete_change AFTER DELETE ON entry BEGIN
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted) VALUES (old.id, 1);
+		END;
+	`),
+
+	// 14: Sync. Entries get an id shared between devices and a version
+	// vector counting the changes made to them on each device. Tombstones
+	// keep the id and version of deleted entries, or only those when the
+	// entry was deleted elsewhere before it got here.
+	migrate.Exec(`
+		INSERT OR IGNORE INTO setting (key, value) VALUES ('device', lower(hex(randomblob(8))));
+		ALTER TABLE entry ADD COLUMN uid text;
+		ALTER TABLE entry ADD COLUMN version text NOT NULL DEFAULT '{}';
+		UPDATE entry SET uid = lower(hex(randomblob(16))), version = json_object((SELECT value FROM setting WHERE key = 'device'), 1);
+		CREATE UNIQUE INDEX entry_uid ON entry (uid);
+
+		DROP TRIGGER after_entry_insert_change;
+		DROP TRIGGER after_entry_update_change;
+		DROP TRIGGER after_entry_delete_change;
+		CREATE TABLE entry_change_new (
+			seq integer PRIMARY KEY AUTOINCREMENT,
+			entry_id integer UNIQUE,
+			uid text UNIQUE,
+			version text,
+			deleted integer NOT NULL DEFAULT 0
+		);
+		INSERT INTO entry_change_new (seq, entry_id, deleted) SELECT seq, entry_id, deleted FROM entry_change;
+		DROP TABLE entry_change;
+		ALTER TABLE entry_change_new RENAME TO entry_change;
+
+		CREATE TRIGGER after_entry_insert_uid AFTER INSERT ON entry WHEN new.uid IS NULL BEGIN
+			UPDATE entry SET
+				uid = lower(hex(randomblob(16))),
+				version = json_object((SELECT value FROM setting WHERE key = 'device'), 1)
+			WHERE id = new.id;
+		END;
+		CREATE TRIGGER after_entry_update_version AFTER UPDATE OF text, color, deleted, parent_id ON entry
+		WHEN new.version IS old.version BEGIN
+			UPDATE entry SET version = json_set(version,
+				'$."' || (SELECT value FROM setting WHERE key = 'device') || '"',
+				coalesce(json_extract(version, '$."' || (SELECT value FROM setting WHERE key = 'device') || '"'), 0) + 1)
+			WHERE id = new.id;
+		END;
+		CREATE TRIGGER after_entry_insert_change AFTER INSERT ON entry BEGIN
+			DELETE FROM entry_change WHERE uid = new.uid;
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted) VALUES (new.id, 0);
+		END;
+		CREATE TRIGGER after_entry_update_change AFTER UPDATE ON entry WHEN old.uid IS NOT NULL BEGIN
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted) VALUES (new.id, 0);
+		END;
+		CREATE TRIGGER after_entry_delete_change AFTER DELETE ON entry BEGIN
+			INSERT OR REPLACE INTO entry_change (entry_id, uid, version, deleted) VALUES (old.id, old.uid, old.version, 1);
+		END;
+	`),
+
+	// 15: Kind of each change, for observers.
+	migrate.Exec(`
+		ALTER TABLE entry_change ADD COLUMN kind text NOT NULL DEFAULT 'update';
+		UPDATE entry_change SET kind = 'delete' WHERE deleted = 1;
+
+		DROP TRIGGER after_entry_insert_change;
+		DROP TRIGGER after_entry_update_change;
+		DROP TRIGGER after_entry_delete_change;
+		CREATE TRIGGER after_entry_insert_change AFTER INSERT ON entry BEGIN
+			DELETE FROM entry_change WHERE uid = new.uid;
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted, kind) VALUES (new.id, 0, 'create');
+		END;
+		CREATE TRIGGER after_entry_update_change AFTER UPDATE ON entry WHEN old.uid IS NOT NULL BEGIN
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted, kind) VALUES (new.id, 0, 'update');
+		END;
+		CREATE TRIGGER after_entry_delete_change AFTER DELETE ON entry BEGIN
+			INSERT OR REPLACE INTO entry_change (entry_id, uid, version, deleted, kind) VALUES (old.id, old.uid, old.version, 1, 'delete');
+		END;
+	`),
+
+	// 16: Epoch of the change feed, replaced when the sequence starts over.
+	migrate.Exec(`
+		INSERT OR IGNORE INTO setting (key, value) VALUES ('feed_epoch', lower(hex(randomblob(8))));
+	`),
+
+	// 17: Versions are counted by the changes that make them, a trigger can't
+	// tell an edit from text being resealed under a new key.
+	migrate.Exec(`
+		DROP TRIGGER after_entry_update_version;
+	`),
+
+	// 18: Tombstones are kept by uid alone, entry ids are reused so a new
+	// entry could otherwise replace the tombstone of an old one.
+	migrate.Exec(`
+		ALTER TABLE entry_change ADD COLUMN deleted_id integer;
+		UPDATE entry_change SET deleted_id = entry_id, entry_id = NULL WHERE deleted = 1;
+
+		DROP TRIGGER after_entry_delete_change;
+		CREATE TRIGGER after_entry_delete_change AFTER DELETE ON entry BEGIN
+			DELETE FROM entry_change WHERE entry_id = old.id;
+			INSERT OR REPLACE INTO entry_change (uid, version, deleted, kind, deleted_id) VALUES (old.uid, old.version, 1, 'delete', old.id);
+		END;
+	`),
+
+	// 19: Replies synced before the entry they reply to, by the uid of the
+	// missing parent.
+	migrate.Exec(`
+		CREATE TABLE entry_orphan (
+			entry_id integer PRIMARY KEY,
+			parent_uid text NOT NULL
+		);
+		CREATE INDEX entry_orphan_parent_uid ON entry_orphan (parent_uid);
+	`),
+
+	// 20: Moving an entry to the trash is reported to observers as a delete
+	// and restoring it as a create. Counting a version always accompanies
+	// another change so it isn't recorded on its own, which would turn those
+	// into updates.
+	migrate.Exec(`
+		UPDATE entry_change SET kind = 'delete' WHERE entry_id IN (SELECT id FROM entry WHERE deleted > 0);
+
+		DROP TRIGGER after_entry_insert_change;
+		DROP TRIGGER after_entry_update_change;
+		CREATE TRIGGER after_entry_insert_change AFTER INSERT ON entry BEGIN
+			DELETE FROM entry_change WHERE uid = new.uid;
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted, kind)
+			VALUES (new.id, 0, CASE WHEN new.deleted > 0 THEN 'delete' ELSE 'create' END);
+		END;
+		CREATE TRIGGER after_entry_update_change AFTER UPDATE OF text, color, created, modified, deleted, pin_order, parent_id ON entry
+		WHEN old.uid IS NOT NULL BEGIN
+			INSERT OR REPLACE INTO entry_change (entry_id, deleted, kind)
+			VALUES (new.id, 0, CASE WHEN new.deleted > 0 THEN 'delete' WHEN old.deleted > 0 THEN 'create' ELSE 'update' END);
+		END;
+	`),
+}
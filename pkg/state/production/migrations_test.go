@@ -0,0 +1,99 @@
+package production
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nathanborror/logger/pkg/migrate"
+)
+
+// openFixture returns the path to a database created from the given SQL file.
+func openFixture(t *testing.T, fixture string) string {
+	query, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "data.logger")
+	conn, err := sqlx.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.MustExec(string(query))
+	return name
+}
+
+func TestMigrations(t *testing.T) {
+	for _, fixture := range []string{"v0.sql", "v2.sql"} {
+		m := New(openFixture(t, fixture)).(*manager)
+
+		version, err := migrate.Version(m.db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != len(migrations) {
+			t.Errorf("%s: version != %d (%d)", fixture, len(migrations), version)
+		}
+
+		var s snapshot
+		if err := json.Unmarshal(m.Current(), &s); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Entries) != 2 {
+			t.Errorf("%s: entries != 2 (%d)", fixture, len(s.Entries))
+		}
+		if err := json.Unmarshal(m.EntrySearch("tag:errands"), &s); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Entries) != 2 {
+			t.Errorf("%s: tagged entries != 2 (%d)", fixture, len(s.Entries))
+		}
+
+		var orphans int
+		if err := m.db.Get(&orphans, `SELECT count(*) FROM entry_index WHERE rowid NOT IN (SELECT id FROM entry)`); err != nil {
+			t.Fatal(err)
+		}
+		if orphans != 0 {
+			t.Errorf("%s: orphaned index rows != 0 (%d)", fixture, orphans)
+		}
+		m.EntryDelete(1)
+		if err := m.db.Get(&orphans, `SELECT count(*) FROM entry_index WHERE rowid NOT IN (SELECT id FROM entry)`); err != nil {
+			t.Fatal(err)
+		}
+		if orphans != 0 {
+			t.Errorf("%s: delete left index row", fixture)
+		}
+	}
+}
+
+func TestMigrationReindexesTags(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data.logger")
+	conn, err := sqlx.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate.Run(conn, migrations[:7]); err != nil {
+		t.Fatal(err)
+	}
+	// Tags used to be split on the first colon, even within values.
+	conn.MustExec(`
+		INSERT INTO entry (text, color, created, modified) VALUES ('call mom #remind=2021-04-01T09:00', 0, 1, 1);
+		DELETE FROM entry_tag;
+		DELETE FROM tag;
+		INSERT INTO tag (id, namespace, key, value) VALUES ('remind=2021-04-01T09:00', 'remind=2021-04-01T09', '00', '');
+		INSERT INTO entry_tag (entry_id, position, tag_id) VALUES (1, 0, 'remind=2021-04-01T09:00');
+	`)
+	conn.Close()
+
+	m := New(name).(*manager)
+	var tags []tag
+	if err := m.db.Select(&tags, `SELECT * FROM tag`); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Namespace != "" || tags[0].Key != "remind" || tags[0].Value != "2021-04-01T09:00" {
+		t.Errorf("expected tags to be reindexed (%+v)", tags)
+	}
+}
@@ -0,0 +1,141 @@
+package production
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nathanborror/logger/pkg/state"
+)
+
+// Observers
+//
+// Observers are notified of the changes recorded in the change feed, see
+// changes.go, so every change is reported no matter what made it. Entries
+// moved to the trash are reported as deleted and restored ones as created,
+// since neither are among the current entries while in the trash. Changes
+// made through this manager are reported as soon as they're committed, on
+// the goroutine that made them. Changes made by other connections to the
+// database, such as another process, are noticed by polling SQLite's
+// data_version while there are observers.
+
+// pollInterval is how often the database is checked for changes made by
+// other connections.
+const pollInterval = time.Second
+
+type notification struct {
+	Changes []notice `json:"changes"`
+	Reset   bool     `json:"reset"` // the database was replaced, reload everything
+}
+
+type notice struct {
+	ID   int64  `json:"id" db:"entry_id"`
+	Kind string `json:"kind" db:"kind"`
+}
+
+type watcher struct {
+	mu        sync.Mutex
+	observers map[int64]state.Observer
+	next      int64         // id of the next observer
+	seq       int64         // the last change notified
+	version   int64         // data_version when last polled
+	stop      chan struct{} // closed to stop polling
+}
+
+// Observe registers o to be notified of changes to entries and returns an
+// id to unregister it with.
+func (m *manager) Observe(o state.Observer) int64 {
+	w := &m.watcher
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.observers) == 0 {
+		m.db.Get(&w.seq, `SELECT coalesce(max(seq), 0) FROM entry_change`)
+		m.db.Get(&w.version, `PRAGMA data_version`)
+		w.observers = map[int64]state.Observer{}
+		w.stop = make(chan struct{})
+		go m.poll(w.stop)
+	}
+	w.next++
+	w.observers[w.next] = o
+	return w.next
+}
+
+// Unobserve unregisters the observer with the given id.
+func (m *manager) Unobserve(id int64) {
+	w := &m.watcher
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.observers[id]; !ok {
+		return
+	}
+	delete(w.observers, id)
+	if len(w.observers) == 0 {
+		close(w.stop)
+	}
+}
+
+// poll notifies observers of changes made by other connections until stop
+// is closed.
+func (m *manager) poll(stop chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		var version int64
+		if err := m.db.Get(&version, `PRAGMA data_version`); err != nil {
+			continue
+		}
+		m.watcher.mu.Lock()
+		changed := version != m.watcher.version
+		m.watcher.version = version
+		m.watcher.mu.Unlock()
+		if changed {
+			m.notify(false)
+		}
+	}
+}
+
+// notify sends observers the changes made since they were last notified, or
+// tells them to reload everything when reset is set or the change feed has
+// gone backwards. Observers are called without holding the lock so they can
+// use the manager.
+func (m *manager) notify(reset bool) {
+	w := &m.watcher
+	w.mu.Lock()
+	if len(w.observers) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	var latest int64
+	if err := m.db.Get(&latest, `SELECT coalesce(max(seq), 0) FROM entry_change`); err != nil || (latest == w.seq && !reset) {
+		w.mu.Unlock()
+		return
+	}
+	n := notification{Changes: []notice{}, Reset: reset || latest < w.seq}
+	if !n.Reset {
+		if err := m.db.Select(&n.Changes, `
+			SELECT coalesce(entry_id, deleted_id) AS entry_id, kind FROM entry_change
+			WHERE seq > $1 AND coalesce(entry_id, deleted_id) IS NOT NULL
+			ORDER BY seq`, w.seq); err != nil {
+			w.mu.Unlock()
+			return
+		}
+	}
+	w.seq = latest
+	observers := make([]state.Observer, 0, len(w.observers))
+	for _, o := range w.observers {
+		observers = append(observers, o)
+	}
+	w.mu.Unlock()
+	if len(n.Changes) == 0 && !n.Reset {
+		return
+	}
+
+	data := encodeResponse(n)
+	for _, o := range observers {
+		o.EntriesChanged(data)
+	}
+}
@@ -0,0 +1,96 @@
+package production
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type recorder chan notification
+
+func (r recorder) EntriesChanged(data []byte) {
+	var n notification
+	if err := json.Unmarshal(data, &n); err != nil {
+		panic(err)
+	}
+	r <- n
+}
+
+// next returns the next notification or fails after timeout.
+func (r recorder) next(t *testing.T, timeout time.Duration) notification {
+	t.Helper()
+	select {
+	case n := <-r:
+		return n
+	case <-time.After(timeout):
+		t.Fatal("expected notification")
+	}
+	return notification{}
+}
+
+func TestObserve(t *testing.T) {
+	db := New(":memory:")
+	r := make(recorder, 10)
+	id := db.Observe(r)
+
+	for _, tt := range []struct {
+		run  func()
+		want []notice
+	}{
+		{func() { db.EntryCreate("one", 0) }, []notice{{1, "create"}}},
+		{func() { db.EntryUpdate(1, "one again", 0) }, []notice{{1, "update"}}},
+		{func() { db.EntryDelete(1) }, []notice{{1, "delete"}}},
+		{func() { db.EntryRestore(1) }, []notice{{1, "create"}}},
+		{func() { db.EntryDelete(1) }, []notice{{1, "delete"}}},
+		{func() { db.TrashEmpty() }, []notice{{1, "delete"}}},
+		{func() { db.EntriesImport([]byte(`[{"text": "two", "created": 1}, {"text": "three", "created": 2}]`)) }, []notice{{2, "create"}, {3, "create"}}},
+		{func() { db.EntryAttach(2, []byte("photo"), "image/png") }, []notice{{2, "update"}}},
+		{func() { db.EntryDetach(2, "55c64d0fcd6f9d5f7c828093857e3fdfda68478bb4e9bd24d481ef391c7804e8") }, []notice{{2, "update"}}},
+	} {
+		tt.run()
+		n := r.next(t, time.Second)
+		if len(n.Changes) != len(tt.want) || n.Reset {
+			t.Fatalf("unexpected notification (%+v)", n)
+		}
+		for i := range tt.want {
+			if n.Changes[i] != tt.want[i] {
+				t.Errorf("notice %d != %+v (%+v)", i, tt.want[i], n.Changes[i])
+			}
+		}
+	}
+
+	// Reads and failed changes aren't notified.
+	db.Current()
+	db.EntryUpdate(99, "missing", 0)
+	db.Unobserve(id)
+	db.EntryCreate("four", 0)
+	select {
+	case n := <-r:
+		t.Errorf("unexpected notification (%+v)", n)
+	default:
+	}
+}
+
+func TestObserveOtherConnection(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data.logger")
+	app := New(name)
+	extension := New(name)
+	r := make(recorder, 10)
+	defer app.Unobserve(app.Observe(r))
+
+	extension.EntryCreate("shared from safari", 0)
+	n := r.next(t, 3*pollInterval)
+	if len(n.Changes) != 1 || n.Changes[0] != (notice{1, "create"}) {
+		t.Errorf("unexpected notification (%+v)", n)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.logger")
+	extension.Backup(path)
+	app.EntryCreate("after backup", 0)
+	r.next(t, time.Second)
+	app.Restore(path)
+	if n := r.next(t, time.Second); !n.Reset {
+		t.Errorf("expected reset after restore (%+v)", n)
+	}
+}
@@ -0,0 +1,41 @@
+package production
+
+// EntryPin pins an entry to the top of the current entries, above any
+// previously pinned entries.
+func (m *manager) EntryPin(id int64) []byte {
+	err := m.transact(func(tx *txn) error {
+		return journaled(tx, journalPin, id, func() error {
+			return setPinOrder(tx, id, `(SELECT max(pin_order) + 1 FROM entry)`)
+		})
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to pin entry"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// EntryUnpin returns a pinned entry to its chronological position.
+func (m *manager) EntryUnpin(id int64) []byte {
+	err := m.transact(func(tx *txn) error {
+		return journaled(tx, journalUnpin, id, func() error {
+			return setPinOrder(tx, id, `0`)
+		})
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to unpin entry"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+func setPinOrder(tx *txn, id int64, order string) error {
+	res, err := tx.Exec(`UPDATE entry SET pin_order = `+order+` WHERE id = $1 AND deleted = 0`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrorNotFound("entry %d not found", id)
+	}
+	return nil
+}
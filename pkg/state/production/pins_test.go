@@ -0,0 +1,68 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEntryPin(t *testing.T) {
+	db := New(":memory:")
+	for _, text := range []string{"one", "two", "three", "four"} {
+		db.EntryCreate(text, 0)
+	}
+	db.(*manager).db.MustExec(`UPDATE entry SET created = id`)
+
+	var s snapshot
+	db.EntryPin(1)
+	if err := json.Unmarshal(db.EntryPin(2), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 4 || ids[0] != 2 || ids[1] != 1 || ids[2] != 4 || ids[3] != 3 {
+		t.Fatalf("unexpected order (%v)", ids)
+	}
+	if !s.Entries[0].Pinned || s.Entries[2].Pinned {
+		t.Errorf("unexpected pinned flags (%+v)", s.Entries)
+	}
+
+	// Pinned entries only appear on the first page, in addition to the limit.
+	if err := json.Unmarshal(db.CurrentPage("", 1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 3 || ids[2] != 4 {
+		t.Fatalf("unexpected first page (%v)", ids)
+	}
+	if err := json.Unmarshal(db.CurrentPage(s.Cursor, 1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 3 || s.Cursor != "" {
+		t.Fatalf("unexpected second page (%v, %q)", ids, s.Cursor)
+	}
+
+	if err := json.Unmarshal(db.EntryUnpin(2), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); ids[0] != 1 || ids[1] != 4 || ids[2] != 3 || ids[3] != 2 {
+		t.Errorf("unexpected order after unpin (%v)", ids)
+	}
+	if err := json.Unmarshal(db.Undo(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); ids[0] != 2 {
+		t.Errorf("unpin not undone (%v)", ids)
+	}
+
+	if err := json.Unmarshal(db.EntryPin(5), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "NotFound" {
+		t.Errorf("expected NotFound error (%+v)", s.Error)
+	}
+}
+
+func entryIDs(entries []entry) []int64 {
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
@@ -1,137 +1,345 @@
 package production
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
+	"math"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3" // driver
+	"github.com/nathanborror/logger/pkg/checklist"
+	"github.com/nathanborror/logger/pkg/migrate"
+	"github.com/nathanborror/logger/pkg/seal"
 	"github.com/nathanborror/logger/pkg/state"
 )
 
 type manager struct {
-	db *sqlx.DB
+	db      *sqlx.DB
+	name    string
+	keyring *seal.Keyring // nil unless the database is encrypted
+	box     *seal.Box     // nil while the database is locked
+	watcher watcher
 }
 
 type entry struct {
-	ID       int64  `json:"id" db:"id"`
-	Text     string `json:"text" db:"text"`
-	Color    int64  `json:"color" db:"color"`
-	Tags     []tag  `json:"tags" db:"-"`
-	Created  int64  `json:"created" db:"created"`
-	Modified int64  `json:"modified" db:"modified"`
+	ID          int64            `json:"id" db:"id"`
+	Text        string           `json:"text" db:"text"`
+	Source      string           `json:"source,omitempty" db:"-"` // the stored text, hashtags and all
+	Color       int64            `json:"color" db:"color"`
+	Tags        []tag            `json:"tags" db:"-"`
+	Created     int64            `json:"created" db:"created"`
+	Modified    int64            `json:"modified" db:"modified"`
+	Deleted     int64            `json:"deleted,omitempty" db:"deleted"` // when the entry was moved to the trash
+	Pinned      bool             `json:"pinned" db:"-"`
+	Checklist   []checklist.Item `json:"checklist,omitempty" db:"-"`
+	Dates       []date           `json:"dates,omitempty" db:"-"`
+	Attachments []attachment     `json:"attachments,omitempty" db:"-"`
+	Links       []link           `json:"links,omitempty" db:"-"`
+	ParentID    int64            `json:"parentId,omitempty" db:"parent_id"` // the entry this is a reply to
+	Replies     int64            `json:"replies" db:"-"`                    // number of direct replies
+	PinOrder    int64            `json:"pinOrder,omitempty" db:"pin_order"` // pinned entries are listed highest first
+	UID         string           `json:"uid" db:"uid"`                      // identifies the entry across devices
+	Version     clock            `json:"version" db:"version"`              // changes made to the entry on each device
+	ParentUID   string           `json:"parentUid,omitempty" db:"-"`        // set in change feeds, see changes.go
+
+	// Populated by searches that match against the full-text index.
+	Matches []match  `json:"matches,omitempty" db:"-"`
+	Snippet *snippet `json:"snippet,omitempty" db:"-"`
+
+	Marked        string  `json:"-" db:"marked"`
+	MarkedSnippet string  `json:"-" db:"marked_snippet"`
+	Score         float64 `json:"-" db:"score"`
 }
 
 // example tags: #value, #namespace:key, #namespace:key=value, #key=value
 type tag struct {
-	ID        string `json:"id"`
-	Namespace string `json:"namespace"`
-	Key       string `json:"key"`
-	Value     string `json:"value"`
+	ID        string `json:"id" db:"id"`
+	Namespace string `json:"namespace" db:"namespace"`
+	Key       string `json:"key" db:"key"`
+	Value     string `json:"value" db:"value"`
 }
 
 type snapshot struct {
 	Entries []entry `json:"entries"`
+	Cursor  string  `json:"cursor"`
 	Error   *Error  `json:"error"`
 }
 
+// cursor marks the position of the last entry on a page.
+type cursor struct {
+	Created int64
+	ID      int64
+	Score   float64 // relevance of the entry for ranked searches
+	Now     int64   // time recency boosts are relative to
+}
+
+// pageLimit is the number of entries mutations return.
+const pageLimit = 50
+
 var (
 	reHashTag = regexp.MustCompile(`\B#\w[\w-:=,.]+`)
-	reSpaces  = regexp.MustCompile(`\s\s+`)
 )
 
 // New sets up a new database if one doesn't already exist.
 func New(name string) state.Stater {
-	conn, err := sqlx.Open("sqlite3", name)
+	m, err := open(name)
 	if err != nil {
 		panic(err)
 	}
+	return m
+}
+
+// open opens the database, migrating it to the latest schema.
+func open(name string) (*manager, error) {
+	conn, err := sqlx.Open("sqlite3", name)
+	if err != nil {
+		return nil, err
+	}
 	if err = conn.Ping(); err != nil {
-		panic(err)
+		return nil, err
 	}
-	if _, err := conn.Exec(`
-		CREATE TABLE IF NOT EXISTS entry (
-			id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
-			text text NOT NULL,
-			color integer NOT NULL,
-			created integer NOT NULL,
-			modified integer NOT NULL
-		);
-		CREATE VIRTUAL TABLE IF NOT EXISTS entry_index USING fts5(text, tokenize=porter);
-		CREATE TRIGGER IF NOT EXISTS after_entry_insert AFTER INSERT ON entry BEGIN
-			INSERT INTO entry_index (rowid, text) VALUES (new.id, new.text);
-		END;
-		CREATE TRIGGER IF NOT EXISTS after_entry_update AFTER UPDATE OF text ON entry BEGIN
-			UPDATE entry_index SET text = new.text WHERE rowid = old.id;
-		END;
-		CREATE TRIGGER IF NOT EXISTS after_entry_insert AFTER DELETE ON entry BEGIN
-			DELETE FROM entry_index WHERE rowid = old.id;
-		END;
-	`); err != nil {
-		panic(err)
+
+	// SQLite only allows a single writer and every connection to an in-memory
+	// database is a separate database, so share one connection.
+	conn.SetMaxOpenConns(1)
+
+	// Keep temporary tables, which hold the indexes of encrypted databases,
+	// out of temporary files.
+	if _, err := conn.Exec(`PRAGMA temp_store = MEMORY`); err != nil {
+		return nil, err
 	}
-	return &manager{db: conn.Unsafe()}
+
+	m := &manager{db: conn.Unsafe(), name: name}
+
+	// Back up before migrating so there's a copy should a migration fail.
+	if dir := m.backupDir(); dir != "" {
+		// Failing to back up shouldn't keep anyone from their entries.
+		if _, err := m.autoBackup(dir, backupInterval); err != nil {
+			log.Printf("failed to back up %s: %s", name, err)
+		}
+	}
+	if err := migrate.Run(conn, migrations); err != nil {
+		return nil, err
+	}
+	if m.keyring, err = loadKeyring(m.db); err != nil {
+		return nil, err
+	}
+	if err := m.run(purgeTrash); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
 // Current returns the latest entries.
 func (m *manager) Current() []byte {
-	var entries []entry
-	if err := m.db.Select(&entries, `SELECT * FROM entry ORDER BY created DESC`); err != nil {
+	return m.CurrentPage("", 0)
+}
+
+// CurrentPage returns up to limit of the latest entries following the given
+// cursor. An empty cursor starts at the most recent entry and a limit of zero
+// returns all remaining entries. Pinned entries are listed at the top of the
+// first page, in pin order, in addition to the limit.
+func (m *manager) CurrentPage(cur string, limit int64) []byte {
+	c, err := decodeCursor(cur)
+	if err != nil {
+		return encodeError(err)
+	}
+	var pinned, entries []entry
+	if cur == "" {
+		if err := m.db.Select(&pinned, `SELECT * FROM entry WHERE deleted = 0 AND pin_order > 0 ORDER BY pin_order DESC`); err != nil {
+			return encodeError(ErrorProgrammerFailure("failed to get pinned entries: %s", err.Error()))
+		}
+	}
+	if err := m.db.Select(&entries, `
+		SELECT * FROM entry
+		WHERE deleted = 0 AND pin_order = 0 AND (created < $1 OR (created = $1 AND id < $2))
+		ORDER BY created DESC, id DESC
+		LIMIT $3`, c.Created, c.ID, fetchLimit(limit)); err != nil {
 		return encodeError(ErrorProgrammerFailure("failed to get entries: %s", err.Error()))
 	}
-	return encodeEntries(entries)
+	entries, next := paginate(entries, limit, 0)
+	entries = append(pinned, entries...)
+	if err := m.loadDetails(entries); err != nil {
+		return encodeError(wrapError(err, "failed to get entry details"))
+	}
+	return encodeResponse(encodeSnapshot(entries, next))
 }
 
 // EntryCreate creates a new entry.
 func (m *manager) EntryCreate(text string, color int64) []byte {
-	now := time.Now().Unix()
-	entry := entry{Text: text, Color: color, Created: now, Modified: now}
-	if _, err := m.db.NamedExec(`INSERT INTO entry (text, color, created, modified) VALUES (:text, :color, :created, :modified)`, entry); err != nil {
-		return encodeError(ErrorProgrammerFailure("failed to create entry: %s", err.Error()))
+	if err := m.transact(func(tx *txn) error {
+		_, err := createEntry(tx, text, color, 0)
+		return err
+	}); err != nil {
+		return encodeError(wrapError(err, "failed to create entry"))
 	}
-	return m.Current()
+	return m.CurrentPage("", pageLimit)
 }
 
 // EntryUpdate updates an existing entry.
 func (m *manager) EntryUpdate(id int64, text string, color int64) []byte {
-	now := time.Now().Unix()
-	entry := entry{ID: id, Text: text, Color: color, Modified: now}
-	if _, err := m.db.NamedExec(`UPDATE entry SET text = :text, color = :color, modified = :modified WHERE id = :id`, entry); err != nil {
-		return encodeError(ErrorProgrammerFailure("failed to update entry: %s", err.Error()))
+	err := m.transact(func(tx *txn) error {
+		return journaled(tx, journalUpdate, id, func() error {
+			if err := requireEntry(tx, id); err != nil {
+				return err
+			}
+			return updateEntry(tx, id, text, color, 0)
+		})
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to update entry"))
 	}
-	return m.Current()
+	return m.CurrentPage("", pageLimit)
 }
 
-// EntryDelete deletes an existing entry.
+// EntryDelete moves an existing entry to the trash.
 func (m *manager) EntryDelete(id int64) []byte {
-	if _, err := m.db.Exec(`DELETE FROM entry WHERE id = $1`, id); err != nil {
-		return encodeError(ErrorProgrammerFailure("failed to delete entry: %s", err.Error()))
+	err := m.transact(func(tx *txn) error {
+		err := journaled(tx, journalDelete, id, func() error {
+			res, err := tx.Exec(`UPDATE entry SET deleted = $1 WHERE id = $2 AND deleted = 0`, time.Now().Unix(), id)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				return ErrorNotFound("entry %d not found", id)
+			}
+			return bumpVersion(tx, id)
+		})
+		if err != nil {
+			return err
+		}
+		return purgeTrash(tx)
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to delete entry"))
 	}
-	return m.Current()
+	return m.CurrentPage("", pageLimit)
 }
 
-func (m *manager) EntrySearch(query string) []byte {
-	var (
-		ids     []int
-		entries []entry
-	)
-	if query == "" {
-		return m.Current()
+// createEntry inserts a new entry, replying to parent when it's non-zero, and
+// returns its id.
+func createEntry(tx *txn, text string, color int64, parent int64) (int64, error) {
+	now := time.Now().Unix()
+	entry := entry{Text: tx.seal(text), Color: color, Created: now, Modified: now, ParentID: parent}
+	res, err := tx.NamedExec(`INSERT INTO entry (text, color, created, modified, parent_id) VALUES (:text, :color, :created, :modified, :parent_id)`, entry)
+	if err != nil {
+		return 0, err
 	}
-	if err := m.db.Select(&ids, `SELECT rowid FROM entry_index WHERE entry_index MATCH 'text:`+query+` * '`); err != nil {
-		return encodeError(ErrorProgrammerFailure("failed to query entries: %s", err.Error()))
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := insertRevision(tx, id, text, color, now, 0); err != nil {
+		return 0, err
 	}
-	query, args, err := sqlx.In(`SELECT * FROM entry WHERE id IN (?) ORDER BY created DESC`, ids)
+	if err := indexEntry(tx, id, text); err != nil {
+		return 0, err
+	}
+	return id, journal(tx, journalCreate, id, nil)
+}
+
+// updateEntry saves new text and color for an entry, recording the change as
+// a revision. A non-zero revert is the revision the change restores.
+func updateEntry(tx *txn, id int64, text string, color int64, revert int64) error {
+	now := time.Now().Unix()
+	entry := entry{ID: id, Text: tx.seal(text), Color: color, Modified: now}
+	res, err := tx.NamedExec(`UPDATE entry SET text = :text, color = :color, modified = :modified WHERE id = :id`, entry)
 	if err != nil {
-		return encodeError(ErrorProgrammerFailure("failed to get matched entries: %s", err.Error()))
+		return err
 	}
-	if err = m.db.Select(&entries, m.db.Rebind(query), args...); err != nil {
-		return encodeError(ErrorProgrammerFailure("failed to get entries: %s", err.Error()))
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrorNotFound("entry %d not found", id)
+	}
+	if err := bumpVersion(tx, id); err != nil {
+		return err
+	}
+	if err := insertRevision(tx, id, text, color, now, revert); err != nil {
+		return err
+	}
+	return indexEntry(tx, id, text)
+}
+
+// indexEntry replaces everything indexed from the text of an entry.
+func indexEntry(tx *txn, id int64, text string) error {
+	if err := saveEntryTags(tx, id, text); err != nil {
+		return err
+	}
+	if err := indexEntryDates(tx, id, text); err != nil {
+		return err
+	}
+	if err := indexEntryLinks(tx, id, text); err != nil {
+		return err
+	}
+	if err := indexEntryReferences(tx, id, text); err != nil {
+		return err
+	}
+	if tx.box == nil {
+		return nil // the full-text index is kept up to date by triggers
+	}
+	return indexEntryText(tx, id, text)
+}
+
+// loadDetails opens the text of entries read from the database and sets the
+// fields stored outside the entry table.
+func (m *manager) loadDetails(entries []entry) error {
+	if err := m.unlocked(); err != nil {
+		return err
+	}
+	for i := range entries {
+		text, err := m.open(entries[i].Text)
+		if err != nil {
+			return err
+		}
+		entries[i].Text = text
+	}
+	if err := m.loadAttachments(entries); err != nil {
+		return err
 	}
-	return encodeEntries(entries)
+	return m.loadReplyCounts(entries)
+}
+
+// txn is a transaction along with the box sealing entry text, which is nil
+// when the database isn't encrypted.
+type txn struct {
+	*sqlx.Tx
+	box *seal.Box
+}
+
+// transact runs fn within a transaction, committing when it succeeds. It
+// fails when the database is locked.
+func (m *manager) transact(fn func(tx *txn) error) error {
+	if err := m.unlocked(); err != nil {
+		return err
+	}
+	return m.run(fn)
+}
+
+// run is transact for changes that don't require the database to be
+// unlocked. Observers are notified once the changes are committed.
+func (m *manager) run(fn func(tx *txn) error) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := fn(&txn{Tx: tx, box: m.box}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.notify(false)
+	return nil
 }
 
 // Errors
@@ -171,9 +379,70 @@ func ErrorProgrammerFailure(message string, a ...interface{}) error {
 	return NewError("ProgrammerFailure", message, a...)
 }
 
+// ErrorNotFound returns a not found Error.
+func ErrorNotFound(message string, a ...interface{}) error {
+	return NewError("NotFound", message, a...)
+}
+
+// ErrorLocked returns an Error for when an encrypted database hasn't been unlocked.
+func ErrorLocked(message string, a ...interface{}) error {
+	return NewError("Locked", message, a...)
+}
+
+// ErrorInvalidPassphrase returns an invalid passphrase Error.
+func ErrorInvalidPassphrase(message string, a ...interface{}) error {
+	return NewError("InvalidPassphrase", message, a...)
+}
+
+// ErrorNotEncrypted returns an Error for when a database isn't encrypted.
+func ErrorNotEncrypted(message string, a ...interface{}) error {
+	return NewError("NotEncrypted", message, a...)
+}
+
+// ErrorInvalidBackup returns an Error for a backup that can't be restored.
+func ErrorInvalidBackup(message string, a ...interface{}) error {
+	return NewError("InvalidBackup", message, a...)
+}
+
+// ErrorInvalidChanges returns an Error for changes from a peer that can't be
+// applied.
+func ErrorInvalidChanges(message string, a ...interface{}) error {
+	return NewError("InvalidChanges", message, a...)
+}
+
+// ErrorEmptyJournal returns an Error for when there's nothing to undo or redo.
+func ErrorEmptyJournal(message string, a ...interface{}) error {
+	return NewError("EmptyJournal", message, a...)
+}
+
+// ErrorInvalidQuery returns an invalid query Error wrapping the parse error.
+func ErrorInvalidQuery(err error) error {
+	return Error{Code: "InvalidQuery", Err: err}
+}
+
+// ErrorInvalidRanking returns an invalid ranking Error.
+func ErrorInvalidRanking(message string, a ...interface{}) error {
+	return NewError("InvalidRanking", message, a...)
+}
+
+// ErrorInvalidCursor returns an invalid cursor Error.
+func ErrorInvalidCursor(message string, a ...interface{}) error {
+	return NewError("InvalidCursor", message, a...)
+}
+
+// wrapError returns err unchanged when it's already an Error, otherwise it
+// wraps it in a programmer failure prefixed with the given message.
+func wrapError(err error, message string) error {
+	switch err.(type) {
+	case Error, *Error:
+		return err
+	}
+	return ErrorProgrammerFailure("%s: %s", message, err.Error())
+}
+
 // Private
 
-func encodeResponse(s snapshot) []byte {
+func encodeResponse(s interface{}) []byte {
 	out, err := json.Marshal(s)
 	if err != nil {
 		return []byte(err.Error())
@@ -181,15 +450,96 @@ func encodeResponse(s snapshot) []byte {
 	return out
 }
 
-func encodeEntries(entries []entry) []byte {
+// fetchLimit returns the number of rows to select for a page of the given
+// limit. One extra row is fetched to determine whether another page follows.
+func fetchLimit(limit int64) int64 {
+	if limit <= 0 {
+		return -1
+	}
+	return limit + 1
+}
+
+func encodePage(entries []entry, limit int64, now int64) []byte {
+	entries, next := paginate(entries, limit, now)
+	return encodeResponse(encodeSnapshot(entries, next))
+}
+
+// paginate trims entries fetched with fetchLimit to the page limit and
+// returns the cursor for the next page, if any.
+func paginate(entries []entry, limit int64, now int64) ([]entry, string) {
+	if limit <= 0 || int64(len(entries)) <= limit {
+		return entries, ""
+	}
+	entries = entries[:limit]
+	last := entries[len(entries)-1]
+	return entries, encodeCursor(cursor{Created: last.Created, ID: last.ID, Score: last.Score, Now: now})
+}
+
+func encodeSnapshot(entries []entry, next string) snapshot {
 	if entries == nil {
 		entries = []entry{}
 	}
 	for i, entry := range entries {
 		entries[i].Tags = encodeEntryTags(entry.Text)
+		entries[i].Source = entry.Text
 		entries[i].Text = encodeEntryText(entry.Text)
+		entries[i].Pinned = entry.PinOrder > 0
+		entries[i].Checklist = encodeEntryChecklist(entry.Text)
+		entries[i].Dates = encodeEntryDates(entry.Text)
+		entries[i].Links = encodeEntryLinks(entry.Text)
+		if entry.Marked != "" {
+			entries[i].Text, entries[i].Matches = decodeHighlight(entry.Marked)
+		}
+		if entry.MarkedSnippet != "" {
+			text, matches := decodeHighlight(entry.MarkedSnippet)
+			entries[i].Snippet = &snippet{Text: text, Matches: matches}
+		}
+	}
+	return snapshot{Entries: entries, Cursor: next}
+}
+
+func encodeCursor(c cursor) string {
+	score := strconv.FormatFloat(c.Score, 'g', -1, 64)
+	str := fmt.Sprintf("%d:%d:%s:%d", c.Created, c.ID, score, c.Now)
+	return base64.RawURLEncoding.EncodeToString([]byte(str))
+}
+
+// decodeCursor returns the position described by the given cursor. An empty
+// cursor is positioned before the first entry.
+func decodeCursor(str string) (cursor, error) {
+	if str == "" {
+		return cursor{Created: math.MaxInt64, ID: math.MaxInt64, Score: math.Inf(-1)}, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return cursor{}, ErrorInvalidCursor("failed to decode cursor: %s", err.Error())
+	}
+	parts := strings.Split(string(data), ":")
+	if len(parts) != 4 {
+		return cursor{}, ErrorInvalidCursor("malformed cursor '%s'", str)
+	}
+	var c cursor
+	if c.Created, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return cursor{}, ErrorInvalidCursor("malformed cursor '%s'", str)
 	}
-	return encodeResponse(snapshot{Entries: entries})
+	if c.ID, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return cursor{}, ErrorInvalidCursor("malformed cursor '%s'", str)
+	}
+	if c.Score, err = strconv.ParseFloat(parts[2], 64); err != nil {
+		return cursor{}, ErrorInvalidCursor("malformed cursor '%s'", str)
+	}
+	if c.Now, err = strconv.ParseInt(parts[3], 10, 64); err != nil {
+		return cursor{}, ErrorInvalidCursor("malformed cursor '%s'", str)
+	}
+	return c, nil
+}
+
+func encodeEntryChecklist(text string) []checklist.Item {
+	items := checklist.Parse(text)
+	for i, item := range items {
+		items[i].Text = encodeEntryText(item.Text)
+	}
+	return items
 }
 
 func encodeEntryTags(text string) []tag {
@@ -211,16 +561,20 @@ func encodeTag(str string) tag {
 		}
 		return "", str
 	}
-	namespace, value := splitter(id, ":")
-	key, value := splitter(value, "=")
+	// Values may contain colons, e.g. #remind=2021-04-01T09:00, so only
+	// look for a namespace before the value.
+	head, tail := id, ""
+	if i := strings.Index(id, "="); i >= 0 {
+		head, tail = id[:i], id[i:]
+	}
+	namespace, value := splitter(head, ":")
+	key, value := splitter(value+tail, "=")
 	tag := tag{ID: id, Namespace: namespace, Key: key, Value: value}
 	return tag
 }
 
 func encodeEntryText(text string) string {
-	cleaned := reHashTag.ReplaceAllString(text, " ")
-	cleaned = reSpaces.ReplaceAllString(cleaned, " ")
-	cleaned = strings.TrimSpace(cleaned)
+	cleaned, _ := cleanText(text)
 	return cleaned
 }
 
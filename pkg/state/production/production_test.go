@@ -83,3 +83,64 @@ func TestEntrySearch(t *testing.T) {
 		t.Errorf(resp.Error.Error())
 	}
 }
+
+func TestCurrentPage(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("foo", 0)
+	db.EntryCreate("bar", 0)
+	db.EntryCreate("baz", 0)
+
+	seen := map[int64]bool{}
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		s := snapshot{}
+		if err := json.Unmarshal(db.CurrentPage(cursor, 2), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error != nil {
+			t.Fatal(s.Error.Error())
+		}
+		for _, entry := range s.Entries {
+			if seen[entry.ID] {
+				t.Errorf("entry %d returned twice", entry.ID)
+			}
+			seen[entry.ID] = true
+		}
+		if s.Cursor == "" {
+			break
+		}
+		cursor = s.Cursor
+	}
+	if len(seen) != 3 {
+		t.Errorf("entries != 3 (%d)", len(seen))
+	}
+
+	s := snapshot{}
+	if err := json.Unmarshal(db.CurrentPage("invalid", 2), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "InvalidCursor" {
+		t.Errorf("expected InvalidCursor error (%+v)", s.Error)
+	}
+}
+
+func TestEntrySearchPage(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("foo one", 0)
+	db.EntryCreate("foo two", 0)
+	db.EntryCreate("bar", 0)
+
+	s := snapshot{}
+	if err := json.Unmarshal(db.EntrySearchPage("foo", "", 1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 1 || s.Cursor == "" {
+		t.Fatalf("expected first page with cursor (%+v)", s)
+	}
+	if err := json.Unmarshal(db.EntrySearchPage("foo", s.Cursor, 1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 1 || s.Cursor != "" {
+		t.Errorf("expected last page without cursor (%+v)", s)
+	}
+}
@@ -0,0 +1,260 @@
+package production
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query syntax:
+//
+//	foo bar          entries containing words starting with foo and bar
+//	"foo bar"        entries containing the exact phrase
+//	-foo             entries not containing foo
+//	foo OR bar       entries containing either foo or bar
+//	tag:work         entries tagged #work, #work:key or #work=value
+//	color:3          entries with color 3
+//	before:2021-03-01, after:2021-03-01
+//	                 entries created before or after the given day
+//
+// Terms separated by whitespace must all match. Filters can be negated and
+// combined with OR just like words.
+
+// term is a single word, phrase or filter within a query.
+type term struct {
+	Pos    int
+	Negate bool
+	Phrase bool
+	Key    string // filter name, empty for words and phrases
+	Value  string
+	Number int64 // parsed filter value for color, before and after
+}
+
+// clause is a list of terms where any one of them must match.
+type clause []term
+
+// search is a query compiled to an FTS5 expression and SQL conditions.
+type search struct {
+	Match string // empty when the query has no required words
+	Where []string
+	Args  []interface{}
+}
+
+// QueryError describes why a search query could not be parsed.
+type QueryError struct {
+	Pos     int
+	Message string
+}
+
+func (e QueryError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Pos)
+}
+
+const dateLayout = "2006-01-02"
+
+// parseQuery parses the given search query into clauses that must all match.
+func parseQuery(str string) ([]clause, error) {
+	var (
+		clauses   []clause
+		pendingOr bool
+		orPos     int
+	)
+	runes := []rune(str)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		t := term{Pos: start}
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			t.Negate = true
+			i++
+		}
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, QueryError{Pos: i, Message: "unterminated quote"}
+			}
+			t.Phrase = true
+			t.Value = string(runes[i+1 : end])
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			t.Value = string(runes[i:end])
+			i = end
+		}
+
+		if !t.Phrase && !t.Negate && t.Value == "OR" {
+			if len(clauses) == 0 || pendingOr {
+				return nil, QueryError{Pos: start, Message: "OR must follow a term"}
+			}
+			pendingOr = true
+			orPos = start
+			continue
+		}
+		if !t.Phrase {
+			if err := parseFilter(&t); err != nil {
+				return nil, err
+			}
+		}
+		if t.Key == "" && !hasWord(t.Value) {
+			if pendingOr {
+				return nil, QueryError{Pos: start, Message: "OR must precede a term"}
+			}
+			continue
+		}
+		if pendingOr {
+			clauses[len(clauses)-1] = append(clauses[len(clauses)-1], t)
+			pendingOr = false
+		} else {
+			clauses = append(clauses, clause{t})
+		}
+	}
+	if pendingOr {
+		return nil, QueryError{Pos: orPos, Message: "OR must precede a term"}
+	}
+	return clauses, nil
+}
+
+// parseFilter recognizes key:value filters, leaving other words untouched.
+func parseFilter(t *term) error {
+	parts := strings.SplitN(t.Value, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	key, value := parts[0], parts[1]
+	pos := t.Pos + len([]rune(key)) + 1
+	if t.Negate {
+		pos++
+	}
+	switch key {
+	case "tag":
+		value = strings.TrimPrefix(value, "#")
+		if value == "" {
+			return QueryError{Pos: pos, Message: "missing tag"}
+		}
+	case "color":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return QueryError{Pos: pos, Message: fmt.Sprintf("invalid color '%s'", value)}
+		}
+		t.Number = n
+	case "before", "after":
+		day, err := time.ParseInLocation(dateLayout, value, time.Local)
+		if err != nil {
+			return QueryError{Pos: pos, Message: fmt.Sprintf("invalid date '%s', expected YYYY-MM-DD", value)}
+		}
+		if key == "after" {
+			day = day.AddDate(0, 0, 1)
+		}
+		t.Number = day.Unix()
+	default:
+		return nil
+	}
+	t.Key = key
+	t.Value = value
+	return nil
+}
+
+// compileQuery compiles clauses into a parameterized search. Clauses made up
+// only of words and phrases are matched against the full-text index directly,
+// everything else becomes an SQL condition on entry.
+func compileQuery(clauses []clause) search {
+	var (
+		s     search
+		match []string
+	)
+	for _, c := range clauses {
+		if c.isText() {
+			var exprs []string
+			for _, t := range c {
+				exprs = append(exprs, t.ftsExpr())
+			}
+			if len(exprs) > 1 {
+				match = append(match, "("+strings.Join(exprs, " OR ")+")")
+			} else {
+				match = append(match, exprs[0])
+			}
+			continue
+		}
+		var conds []string
+		for _, t := range c {
+			cond, args := t.sqlExpr()
+			conds = append(conds, cond)
+			s.Args = append(s.Args, args...)
+		}
+		s.Where = append(s.Where, "("+strings.Join(conds, " OR ")+")")
+	}
+	s.Match = strings.Join(match, " AND ")
+	return s
+}
+
+func (c clause) isText() bool {
+	for _, t := range c {
+		if t.Negate || t.Key != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ftsExpr returns the term as a quoted FTS5 string. Words are prefix matched.
+func (t term) ftsExpr() string {
+	quoted := `"` + strings.ReplaceAll(t.Value, `"`, `""`) + `"`
+	if t.Phrase {
+		return quoted
+	}
+	return quoted + "*"
+}
+
+// sqlExpr returns the term as an SQL condition on entry along with its arguments.
+func (t term) sqlExpr() (string, []interface{}) {
+	var (
+		cond string
+		args []interface{}
+	)
+	switch t.Key {
+	case "":
+		cond = `entry.id IN (SELECT rowid FROM entry_index WHERE entry_index MATCH ?)`
+		args = []interface{}{t.ftsExpr()}
+	case "tag":
+		cond = `entry.id IN (
+			SELECT entry_tag.entry_id FROM entry_tag
+			JOIN tag ON tag.id = entry_tag.tag_id
+			WHERE tag.id = ? COLLATE NOCASE OR tag.namespace = ? COLLATE NOCASE OR tag.key = ? COLLATE NOCASE
+		)`
+		args = []interface{}{t.Value, t.Value, t.Value}
+	case "color":
+		cond = `entry.color = ?`
+		args = []interface{}{t.Number}
+	case "before":
+		cond = `entry.created < ?`
+		args = []interface{}{t.Number}
+	case "after":
+		cond = `entry.created >= ?`
+		args = []interface{}{t.Number}
+	}
+	if t.Negate {
+		cond = "NOT " + cond
+	}
+	return cond, args
+}
+
+// hasWord reports whether the string contains anything the tokenizer indexes.
+func hasWord(str string) bool {
+	for _, r := range str {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
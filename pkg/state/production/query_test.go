@@ -0,0 +1,91 @@
+package production
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	clauses, err := parseQuery(`foo "bar baz" -qux tag:work OR color:3 after:2021-03-01`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clauses) != 5 {
+		t.Fatalf("clauses != 5 (%+v)", clauses)
+	}
+	if !clauses[1][0].Phrase || clauses[1][0].Value != "bar baz" {
+		t.Errorf("expected phrase (%+v)", clauses[1])
+	}
+	if !clauses[2][0].Negate {
+		t.Errorf("expected negated term (%+v)", clauses[2])
+	}
+	if len(clauses[3]) != 2 || clauses[3][0].Key != "tag" || clauses[3][1].Key != "color" {
+		t.Errorf("expected tag OR color (%+v)", clauses[3])
+	}
+
+	s := compileQuery(clauses)
+	if s.Match != `"foo"* AND "bar baz"` {
+		t.Errorf("unexpected match expression (%s)", s.Match)
+	}
+	if len(s.Where) != 3 {
+		t.Errorf("where != 3 (%+v)", s.Where)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		`"foo`,
+		`OR foo`,
+		`foo OR`,
+		`foo OR OR bar`,
+		`color:red`,
+		`before:yesterday`,
+		`tag:`,
+	} {
+		_, err := parseQuery(query)
+		var qerr QueryError
+		if !errors.As(err, &qerr) {
+			t.Errorf("expected QueryError for %q (%v)", query, err)
+		}
+	}
+}
+
+func TestEntrySearchQuery(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("buy milk #errands", 1)
+	db.EntryCreate("fix the build #work", 2)
+	db.EntryCreate("it's a \"quoted\" note - with hyphens #work", 2)
+
+	for query, count := range map[string]int{
+		`tag:work`:              2,
+		`tag:work -build`:       1,
+		`milk OR build`:         2,
+		`color:1`:               1,
+		`-tag:work`:             1,
+		`"quoted" note`:         1,
+		`it's - "with hyphens"`: 1,
+		`NEAR( AND *`:           0,
+		`before:2000-01-01`:     0,
+		`after:2000-01-01 milk`: 1,
+	} {
+		s := snapshot{}
+		if err := json.Unmarshal(db.EntrySearch(query), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error != nil {
+			t.Errorf("%q: %s", query, s.Error.Error())
+		}
+		if len(s.Entries) != count {
+			t.Errorf("%q: entries != %d (%d)", query, count, len(s.Entries))
+		}
+	}
+
+	s := snapshot{}
+	if err := json.Unmarshal(db.EntrySearch(`"unterminated`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "InvalidQuery" {
+		t.Errorf("expected InvalidQuery error (%+v)", s.Error)
+	}
+}
@@ -0,0 +1,113 @@
+package production
+
+import (
+	"github.com/nathanborror/logger/pkg/wikilink"
+)
+
+// reference is a [[link]] from one entry to another by id or title. Titles
+// are read from the full-text index which holds the text of entries even when
+// it's sealed in the entry table. Links are resolved when they're read so a
+// link to a title can be written before the entry it refers to. Dangling
+// links have an EntryID of zero.
+type reference struct {
+	Position int64  `json:"position" db:"position"`
+	Target   string `json:"target" db:"target"`
+	EntryID  int64  `json:"entryId" db:"resolved"`
+	Dangling bool   `json:"dangling" db:"-"`
+}
+
+type referenceList struct {
+	References []reference `json:"references"`
+	Error      *Error      `json:"error"`
+}
+
+// EntryReferences returns the links from an entry to other entries in the
+// order they appear, reporting those not matching any current entry as
+// dangling.
+func (m *manager) EntryReferences(id int64) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var list []reference
+	err := requireEntry(m.db, id)
+	if err == nil {
+		err = m.db.Select(&list, `
+			SELECT entry_reference.position, entry_reference.target, coalesce(
+				(SELECT entry.id FROM entry WHERE entry.deleted = 0 AND CAST(entry.id AS text) = entry_reference.target),
+				(SELECT entry.id FROM entry WHERE entry.deleted = 0 AND `+wikilink.TitleSQL("(SELECT entry_index.text FROM entry_index WHERE entry_index.rowid = entry.id)")+` = entry_reference.target COLLATE NOCASE
+					ORDER BY entry.created DESC LIMIT 1),
+				0
+			) AS resolved
+			FROM entry_reference
+			WHERE entry_reference.entry_id = $1
+			ORDER BY entry_reference.position`, id)
+	}
+	if err != nil {
+		return encodeError(wrapError(err, "failed to get references"))
+	}
+	if list == nil {
+		list = []reference{}
+	}
+	for i := range list {
+		list[i].Dangling = list[i].EntryID == 0
+	}
+	return encodeResponse(referenceList{References: list})
+}
+
+// EntryBacklinks returns the entries linking to an entry by id or title,
+// newest first.
+func (m *manager) EntryBacklinks(id int64) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var entries []entry
+	err := requireEntry(m.db, id)
+	if err == nil {
+		err = m.db.Select(&entries, `
+			SELECT * FROM entry
+			WHERE deleted = 0 AND id != $1 AND id IN (
+				SELECT entry_reference.entry_id FROM entry_reference, entry AS target
+				WHERE target.id = $1 AND (
+					entry_reference.target = CAST(target.id AS text) OR
+					entry_reference.target = `+wikilink.TitleSQL("(SELECT entry_index.text FROM entry_index WHERE entry_index.rowid = target.id)")+` COLLATE NOCASE
+				)
+			)
+			ORDER BY created DESC, id DESC`, id)
+	}
+	if err != nil {
+		return encodeError(wrapError(err, "failed to get backlinks"))
+	}
+	if err := m.loadDetails(entries); err != nil {
+		return encodeError(wrapError(err, "failed to get entry details"))
+	}
+	return encodeResponse(encodeSnapshot(entries, ""))
+}
+
+// indexEntryReferences replaces the indexed links from an entry with those
+// found in text.
+func indexEntryReferences(tx *txn, id int64, text string) error {
+	if _, err := tx.Exec(`DELETE FROM entry_reference WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	for i, target := range wikilink.Parse(text) {
+		if _, err := tx.Exec(`INSERT INTO entry_reference (entry_id, position, target) VALUES ($1, $2, $3)`, id, i, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillReferences indexes the links of every entry, used when upgrading a
+// database created before links between entries were indexed.
+func backfillReferences(tx *txn) error {
+	var entries []entry
+	if err := tx.Select(&entries, `SELECT id, text FROM entry`); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := indexEntryReferences(tx, entry.ID, entry.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReferences(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("Reading list\n- books", 0)
+	db.EntryCreate("see [[reading LIST]] and [[1]] and [[Someday]]", 0)
+	db.EntryCreate("also [[1]]", 0)
+
+	var refs referenceList
+	if err := json.Unmarshal(db.EntryReferences(2), &refs); err != nil {
+		t.Fatal(err)
+	}
+	expected := []reference{
+		{Position: 0, Target: "reading LIST", EntryID: 1},
+		{Position: 1, Target: "1", EntryID: 1},
+		{Position: 2, Target: "Someday", Dangling: true},
+	}
+	if len(refs.References) != len(expected) {
+		t.Fatalf("unexpected references (%+v)", refs.References)
+	}
+	for i, ref := range refs.References {
+		if ref != expected[i] {
+			t.Errorf("reference %d: expected %+v, got %+v", i, expected[i], ref)
+		}
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(db.EntryBacklinks(1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 2 {
+		t.Errorf("unexpected backlinks (%v)", ids)
+	}
+
+	// Links to a title resolve once an entry has that title.
+	db.EntryCreate("Someday", 0)
+	if err := json.Unmarshal(db.EntryReferences(2), &refs); err != nil {
+		t.Fatal(err)
+	}
+	if ref := refs.References[2]; ref.Dangling || ref.EntryID != 4 {
+		t.Errorf("expected resolved reference (%+v)", ref)
+	}
+	db.EntryDelete(4)
+	if err := json.Unmarshal(db.EntryReferences(2), &refs); err != nil {
+		t.Fatal(err)
+	}
+	if ref := refs.References[2]; !ref.Dangling {
+		t.Errorf("expected dangling reference to trashed entry (%+v)", ref)
+	}
+
+	db.EntryDelete(3)
+	if err := json.Unmarshal(db.EntryBacklinks(1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if ids := entryIDs(s.Entries); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("unexpected backlinks (%v)", ids)
+	}
+}
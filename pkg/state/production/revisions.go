@@ -0,0 +1,86 @@
+package production
+
+import (
+	"database/sql"
+)
+
+// revision is the text and color of an entry as saved at a point in time.
+type revision struct {
+	ID       int64  `json:"id" db:"id"`
+	EntryID  int64  `json:"entryId" db:"entry_id"`
+	Text     string `json:"text" db:"text"`
+	Color    int64  `json:"color" db:"color"`
+	Tags     []tag  `json:"tags" db:"-"`
+	Created  int64  `json:"created" db:"created"`
+	RevertOf int64  `json:"revertOf,omitempty" db:"revert_of"` // revision restored by this revision
+}
+
+type revisions struct {
+	Revisions []revision `json:"revisions"`
+	Error     *Error     `json:"error"`
+}
+
+// EntryRevisions returns every saved revision of an entry, newest first. The
+// first revision is always the entry's current text and color.
+func (m *manager) EntryRevisions(id int64) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var list []revision
+	if err := m.db.Select(&list, `SELECT * FROM entry_revision WHERE entry_id = $1 ORDER BY id DESC`, id); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get revisions: %s", err.Error()))
+	}
+	if len(list) == 0 {
+		return encodeError(ErrorNotFound("entry %d not found", id))
+	}
+	for i, r := range list {
+		text, err := m.open(r.Text)
+		if err != nil {
+			return encodeError(wrapError(err, "failed to open revision"))
+		}
+		list[i].Text = text
+	}
+	return encodeRevisions(list)
+}
+
+// EntryRevert restores an entry to the text and color of the given revision.
+// The revert is itself recorded as a new revision so it can be undone.
+func (m *manager) EntryRevert(id int64, revisionID int64) []byte {
+	err := m.transact(func(tx *txn) error {
+		var r revision
+		if err := tx.Get(&r, `SELECT * FROM entry_revision WHERE id = $1 AND entry_id = $2`, revisionID, id); err == sql.ErrNoRows {
+			return ErrorNotFound("revision %d of entry %d not found", revisionID, id)
+		} else if err != nil {
+			return err
+		}
+		text, err := tx.open(r.Text)
+		if err != nil {
+			return err
+		}
+		r.Text = text
+		return journaled(tx, journalRevert, id, func() error {
+			if err := requireEntry(tx, id); err != nil {
+				return err
+			}
+			return updateEntry(tx, id, r.Text, r.Color, r.ID)
+		})
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to revert entry"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// insertRevision records the text and color an entry was saved with.
+func insertRevision(tx *txn, id int64, text string, color int64, created int64, revert int64) error {
+	_, err := tx.Exec(`INSERT INTO entry_revision (entry_id, text, color, created, revert_of) VALUES ($1, $2, $3, $4, $5)`, id, tx.seal(text), color, created, revert)
+	return err
+}
+
+func encodeRevisions(list []revision) []byte {
+	for i, r := range list {
+		list[i].Tags = encodeEntryTags(r.Text)
+		list[i].Text = encodeEntryText(r.Text)
+	}
+	return encodeResponse(revisions{Revisions: list})
+}
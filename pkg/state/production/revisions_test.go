@@ -0,0 +1,48 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEntryRevisions(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("first", 0)
+	db.EntryUpdate(1, "second", 1)
+	db.EntryUpdate(1, "third", 2)
+
+	var r revisions
+	if err := json.Unmarshal(db.EntryRevisions(1), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Error != nil {
+		t.Fatal(r.Error.Error())
+	}
+	if len(r.Revisions) != 3 || r.Revisions[0].Text != "third" || r.Revisions[2].Text != "first" {
+		t.Fatalf("unexpected revisions (%+v)", r.Revisions)
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(db.EntryRevert(1, r.Revisions[2].ID), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error != nil {
+		t.Fatal(s.Error.Error())
+	}
+	if s.Entries[0].Text != "first" || s.Entries[0].Color != 0 {
+		t.Errorf("entry not reverted (%+v)", s.Entries[0])
+	}
+	if err := json.Unmarshal(db.EntryRevisions(1), &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Revisions) != 4 || r.Revisions[0].RevertOf != r.Revisions[3].ID {
+		t.Errorf("revert not recorded (%+v)", r.Revisions)
+	}
+
+	if err := json.Unmarshal(db.EntryRevert(1, 999), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "NotFound" {
+		t.Errorf("expected NotFound error (%+v)", s.Error)
+	}
+}
@@ -0,0 +1,112 @@
+package production
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nathanborror/logger/pkg/state"
+)
+
+// recencyHalfLife is the age in seconds at which the recency boost has
+// fallen to half its initial value.
+const recencyHalfLife = 30 * 24 * 60 * 60
+
+// EntrySearch returns all entries matching the given query.
+func (m *manager) EntrySearch(query string) []byte {
+	return m.EntrySearchPage(query, "", 0)
+}
+
+// EntrySearchPage returns up to limit entries matching the given query
+// following the given cursor. See query.go for the query syntax.
+func (m *manager) EntrySearchPage(query string, cur string, limit int64) []byte {
+	return m.EntrySearchRanked(query, state.RankChronological, cur, limit)
+}
+
+// EntrySearchRanked returns up to limit entries matching the given query
+// following the given cursor, ordered by the given ranking. Relevance is
+// scored with bm25 and only applies to queries containing words or phrases,
+// other queries are always ordered chronologically.
+func (m *manager) EntrySearchRanked(query string, ranking string, cur string, limit int64) []byte {
+	switch ranking {
+	case state.RankChronological, state.RankRelevance, state.RankRecency:
+	default:
+		return encodeError(ErrorInvalidRanking("unknown ranking '%s'", ranking))
+	}
+	clauses, err := parseQuery(query)
+	if err != nil {
+		return encodeError(ErrorInvalidQuery(err))
+	}
+	if len(clauses) == 0 {
+		return m.CurrentPage(cur, limit)
+	}
+	c, err := decodeCursor(cur)
+	if err != nil {
+		return encodeError(err)
+	}
+	search := compileQuery(clauses)
+	if search.Match == "" {
+		ranking = state.RankChronological
+	}
+
+	var (
+		columns = `entry.*`
+		from    = `entry`
+		where   []string
+		args    []interface{}
+		now     int64
+	)
+	if search.Match != "" {
+		columns += `, highlight(entry_index, 0, ?, ?) AS marked, snippet(entry_index, 0, ?, ?, '…', ?) AS marked_snippet`
+		args = append(args, markOpen, markClose, markOpen, markClose, snippetTokens)
+		switch ranking {
+		case state.RankRelevance:
+			columns += `, bm25(entry_index) AS score`
+		case state.RankRecency:
+			// bm25 scores are negative with better matches lower, scaling
+			// them by up to 2x for brand new entries moves those forward.
+			now = c.Now
+			if cur == "" {
+				now = time.Now().Unix()
+			}
+			columns += `, bm25(entry_index) * (1.0 + 1.0 / (1.0 + max(? - entry.created, 0) / ?)) AS score`
+			args = append(args, now, float64(recencyHalfLife))
+		}
+		from += ` JOIN entry_index ON entry_index.rowid = entry.id`
+		where = append(where, `entry_index MATCH ?`)
+		args = append(args, search.Match)
+	}
+	where = append(where, `entry.deleted = 0`)
+	where = append(where, search.Where...)
+	args = append(args, search.Args...)
+
+	var (
+		position string
+		order    string
+	)
+	if ranking == state.RankChronological {
+		position = `created < ? OR (created = ? AND id < ?)`
+		order = `created DESC, id DESC`
+		args = append(args, c.Created, c.Created, c.ID)
+	} else {
+		position = `score > ? OR (score = ? AND id < ?)`
+		order = `score ASC, id DESC`
+		args = append(args, c.Score, c.Score, c.ID)
+	}
+	args = append(args, fetchLimit(limit))
+
+	var entries []entry
+	if err := m.db.Select(&entries, `
+		SELECT * FROM (
+			SELECT `+columns+` FROM `+from+`
+			WHERE `+strings.Join(where, " AND ")+`
+		)
+		WHERE `+position+`
+		ORDER BY `+order+`
+		LIMIT ?`, args...); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to query entries: %s", err.Error()))
+	}
+	if err := m.loadDetails(entries); err != nil {
+		return encodeError(wrapError(err, "failed to get entry details"))
+	}
+	return encodePage(entries, limit, now)
+}
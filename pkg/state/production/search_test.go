@@ -0,0 +1,69 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nathanborror/logger/pkg/state"
+)
+
+func TestEntrySearchRanked(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("oat milk", 0)
+	db.EntryCreate("a long rambling note that mentions milk once in passing among many other words", 0)
+	db.(*manager).db.MustExec(`UPDATE entry SET created = created - 86400 * 365 WHERE id = 1`)
+
+	for ranking, first := range map[string]int64{
+		state.RankChronological: 2,
+		state.RankRelevance:     1,
+		state.RankRecency:       1,
+	} {
+		s := snapshot{}
+		if err := json.Unmarshal(db.EntrySearchRanked("milk", ranking, "", 0), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error != nil {
+			t.Fatalf("%s: %s", ranking, s.Error.Error())
+		}
+		if len(s.Entries) != 2 {
+			t.Fatalf("%s: entries != 2 (%d)", ranking, len(s.Entries))
+		}
+		if s.Entries[0].ID != first {
+			t.Errorf("%s: first entry %d, expected %d", ranking, s.Entries[0].ID, first)
+		}
+	}
+
+	s := snapshot{}
+	if err := json.Unmarshal(db.EntrySearchRanked("milk", "random", "", 0), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "InvalidRanking" {
+		t.Errorf("expected InvalidRanking error (%+v)", s.Error)
+	}
+}
+
+func TestEntrySearchRankedPage(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("milk", 0)
+	db.EntryCreate("milk and eggs", 0)
+	db.EntryCreate("milk and eggs and bread", 0)
+
+	seen := map[int64]bool{}
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		s := snapshot{}
+		if err := json.Unmarshal(db.EntrySearchRanked("milk", state.RankRecency, cursor, 1), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error != nil {
+			t.Fatal(s.Error.Error())
+		}
+		for _, entry := range s.Entries {
+			seen[entry.ID] = true
+		}
+		cursor = s.Cursor
+	}
+	if len(seen) != 3 || cursor != "" {
+		t.Errorf("expected all entries across pages (%v, %q)", seen, cursor)
+	}
+}
@@ -0,0 +1,48 @@
+package production
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Setting keys.
+const (
+	settingTrashRetention  = "trash_retention" // days
+	settingUndoDepth       = "undo_depth"
+	settingEncryption      = "encryption" // keyring JSON
+	settingBackupCount     = "backup_count"
+	settingDevice          = "device"      // identifies the database when syncing
+	settingFeedEpoch       = "feed_epoch"  // replaced whenever the change feed starts over
+	settingSyncPrefix      = "sync:"       // followed by a peer device, the last change received from it
+	settingSyncEpochPrefix = "sync_epoch:" // followed by a peer device, the epoch of the peer's feed
+)
+
+// settingInt returns the integer value of a setting or fallback when unset.
+func settingInt(q sqlx.Queryer, key string, fallback int64) (int64, error) {
+	var value string
+	if err := sqlx.Get(q, &value, `SELECT value FROM setting WHERE key = $1`, key); err == sql.ErrNoRows {
+		return fallback, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// settingString returns the value of a setting or fallback when unset.
+func settingString(q sqlx.Queryer, key string, fallback string) (string, error) {
+	var value string
+	if err := sqlx.Get(q, &value, `SELECT value FROM setting WHERE key = $1`, key); err == sql.ErrNoRows {
+		return fallback, nil
+	} else if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// setSetting saves the value of a setting.
+func setSetting(tx *txn, key string, value string) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO setting (key, value) VALUES ($1, $2)`, key, value)
+	return err
+}
@@ -0,0 +1,451 @@
+package production
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sync
+//
+// Databases converge by exchanging change feeds, see changes.go. Each entry
+// carries a version vector counting the changes made to it on each device,
+// see bumpVersion, so applying a change from a peer either
+// replaces the entry, is ignored because the entry already has it, or
+// conflicts because both devices changed the entry since they last synced.
+// Conflicts are resolved the same way on both devices: the most recently
+// modified version wins and the other is kept as a new entry tagged
+// #conflict whose uid is derived from the losing version, so devices that
+// resolve the same conflict create the same entry. Edits win over deletes.
+// The feed only keeps the latest change of each entry so replies can arrive
+// before the entry they reply to, they're attached once it does. Pins and
+// attachments aren't synced.
+
+// conflictTag marks entries holding the losing side of a conflict.
+const conflictTag = "#conflict"
+
+// Orderings of version vectors.
+const (
+	clockEqual = iota
+	clockBefore
+	clockAfter
+	clockConcurrent
+)
+
+// clock is a version vector counting the changes made on each device.
+type clock map[string]int64
+
+type syncCursor struct {
+	Device string `json:"device"` // this database
+	Peer   string `json:"peer"`
+	Epoch  string `json:"epoch"` // the epoch of peer's feed seq belongs to
+	Seq    int64  `json:"seq"`   // the last change received from peer
+	Error  *Error `json:"error"`
+}
+
+type syncSummary struct {
+	Peer      string `json:"peer"`
+	Seq       int64  `json:"seq"`
+	Inserted  int64  `json:"inserted"`
+	Updated   int64  `json:"updated"`
+	Deleted   int64  `json:"deleted"`
+	Conflicts int64  `json:"conflicts"`
+	Skipped   int64  `json:"skipped"`
+	Error     *Error `json:"error"`
+}
+
+// SyncCursor returns the epoch and sequence of the last change received from
+// peer, to pass to the peer's ChangesSince, along with this database's
+// device.
+func (m *manager) SyncCursor(peer string) []byte {
+	cur := syncCursor{Peer: peer}
+	err := m.db.Get(&cur.Device, `SELECT value FROM setting WHERE key = $1`, settingDevice)
+	if err == nil {
+		cur.Epoch, err = settingString(m.db, settingSyncEpochPrefix+peer, "")
+	}
+	if err == nil {
+		cur.Seq, err = settingInt(m.db, settingSyncPrefix+peer, 0)
+	}
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get sync cursor: %s", err.Error()))
+	}
+	return encodeResponse(cur)
+}
+
+// SyncApply applies changes returned by a peer's ChangesSince and records
+// how far this database has got through the peer's changes. Each batch is
+// applied in a single transaction so an interrupted sync resumes from the
+// last batch applied.
+func (m *manager) SyncApply(data []byte) []byte {
+	var set changes
+	if err := json.Unmarshal(data, &set); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to decode changes: %s", err.Error()))
+	}
+	if set.Device == "" {
+		return encodeError(ErrorInvalidChanges("changes are missing a device"))
+	}
+	summary := syncSummary{Peer: set.Device, Seq: set.Seq}
+	err := m.transact(func(tx *txn) error {
+		var device string
+		if err := tx.Get(&device, `SELECT value FROM setting WHERE key = $1`, settingDevice); err != nil {
+			return err
+		}
+		if set.Device == device {
+			return ErrorInvalidChanges("changes were made in this database")
+		}
+		for _, e := range set.Entries {
+			if e.UID == "" {
+				return ErrorInvalidChanges("entry %d is missing a uid", e.ID)
+			}
+			if err := applyRemoteEntry(tx, e, &summary); err != nil {
+				return err
+			}
+		}
+		for _, t := range set.Tombstones {
+			if err := applyTombstone(tx, t, &summary); err != nil {
+				return err
+			}
+		}
+		if err := setSetting(tx, settingSyncEpochPrefix+set.Device, set.Epoch); err != nil {
+			return err
+		}
+		return setSetting(tx, settingSyncPrefix+set.Device, strconv.FormatInt(set.Seq, 10))
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to apply changes"))
+	}
+	return encodeResponse(summary)
+}
+
+// applyRemoteEntry applies a change made to an entry on another device.
+func applyRemoteEntry(tx *txn, remote entry, summary *syncSummary) error {
+	local, err := loadEntryByUID(tx, remote.UID)
+	if err != nil {
+		return err
+	}
+	if local == nil {
+		dead, err := loadTombstone(tx, remote.UID)
+		if err != nil {
+			return err
+		}
+		if dead != nil && remote.Version.compare(dead.Version) != clockAfter && remote.Version.compare(dead.Version) != clockConcurrent {
+			summary.Skipped++
+			return nil
+		}
+		summary.Inserted++
+		return insertRemoteEntry(tx, remote)
+	}
+
+	switch local.Version.compare(remote.Version) {
+	case clockEqual, clockAfter:
+		summary.Skipped++
+		return nil
+	case clockBefore:
+		summary.Updated++
+		return updateRemoteEntry(tx, *local, remote, remote.Version)
+	}
+
+	// Both devices changed the entry, keep the losing text as a new entry.
+	summary.Conflicts++
+	winner, loser := remote, *local
+	if newer(*local, remote) {
+		winner, loser = *local, remote
+	}
+	if loser.Text != winner.Text {
+		copy := loser
+		copy.UID = conflictUID(loser)
+		if !strings.Contains(copy.Text, conflictTag) {
+			copy.Text += "\n\n" + conflictTag
+		}
+		existing, err := loadEntryByUID(tx, copy.UID)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			if err := insertRemoteEntry(tx, copy); err != nil {
+				return err
+			}
+		}
+	}
+	return updateRemoteEntry(tx, *local, winner, local.Version.merge(remote.Version))
+}
+
+// applyTombstone applies the permanent deletion of an entry on another
+// device. Entries changed here since aren't deleted.
+func applyTombstone(tx *txn, t tombstone, summary *syncSummary) error {
+	local, err := loadEntryByUID(tx, t.UID)
+	if err != nil {
+		return err
+	}
+	if local != nil {
+		switch local.Version.compare(t.Version) {
+		case clockBefore, clockEqual:
+		default:
+			summary.Skipped++
+			return nil
+		}
+		if err := removeEntry(tx, local.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM journal WHERE entry_id = $1`, local.ID); err != nil {
+			return err
+		}
+	}
+	dead, err := loadTombstone(tx, t.UID)
+	if err != nil {
+		return err
+	}
+	version := t.Version
+	if dead != nil {
+		if order := dead.Version.compare(t.Version); order == clockEqual || order == clockAfter {
+			summary.Skipped++
+			return nil
+		}
+		version = dead.Version.merge(t.Version)
+	}
+	if local != nil {
+		summary.Deleted++
+	} else {
+		summary.Skipped++
+	}
+	_, err = tx.Exec(`INSERT OR REPLACE INTO entry_change (deleted_id, uid, version, deleted, kind) VALUES ($1, $2, $3, 1, 'delete')`,
+		sql.NullInt64{Int64: idOf(local), Valid: local != nil}, t.UID, version)
+	return err
+}
+
+// insertRemoteEntry inserts an entry created on another device, adopting
+// replies to it that arrived first.
+func insertRemoteEntry(tx *txn, e entry) error {
+	parent, err := resolveParent(tx, e.ParentUID)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`INSERT INTO entry (uid, version, text, color, created, modified, deleted, parent_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		e.UID, e.Version, tx.seal(e.Text), e.Color, e.Created, e.Modified, e.Deleted, parent)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := setOrphan(tx, id, parent, e.ParentUID); err != nil {
+		return err
+	}
+	if err := adoptOrphans(tx, id, e.UID); err != nil {
+		return err
+	}
+	if err := insertRevision(tx, id, e.Text, e.Color, e.Modified, 0); err != nil {
+		return err
+	}
+	return indexEntry(tx, id, e.Text)
+}
+
+// updateRemoteEntry replaces the synced fields of a local entry, taking the
+// merged version. Undo history for the entry is discarded since it no longer
+// applies.
+func updateRemoteEntry(tx *txn, local entry, e entry, version clock) error {
+	parent, err := resolveParent(tx, e.ParentUID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE entry SET text = $1, color = $2, created = $3, modified = $4, deleted = $5, parent_id = $6, version = $7 WHERE id = $8`,
+		tx.seal(e.Text), e.Color, e.Created, e.Modified, e.Deleted, parent, version, local.ID); err != nil {
+		return err
+	}
+	if err := setOrphan(tx, local.ID, parent, e.ParentUID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM journal WHERE entry_id = $1`, local.ID); err != nil {
+		return err
+	}
+	if local.Text == e.Text && local.Color == e.Color {
+		return nil
+	}
+	if err := insertRevision(tx, local.ID, e.Text, e.Color, e.Modified, 0); err != nil {
+		return err
+	}
+	return indexEntry(tx, local.ID, e.Text)
+}
+
+// bumpVersion counts a change made here to the text, color, trash state or
+// parent of an entry, which tells peers the entry has changed. New entries
+// start at a count of one, see migration 14.
+func bumpVersion(tx *txn, id int64) error {
+	device, err := settingString(tx, settingDevice, "")
+	if err != nil {
+		return err
+	}
+	var version clock
+	if err := tx.Get(&version, `SELECT version FROM entry WHERE id = $1`, id); err != nil {
+		return err
+	}
+	version[device]++
+	_, err = tx.Exec(`UPDATE entry SET version = $1 WHERE id = $2`, version, id)
+	return err
+}
+
+// loadEntryByUID returns the entry with the given uid, with the uid of its
+// parent, or nil when it doesn't exist.
+func loadEntryByUID(tx *txn, uid string) (*entry, error) {
+	var e entry
+	if err := tx.Get(&e, `SELECT * FROM entry WHERE uid = $1`, uid); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	text, err := tx.open(e.Text)
+	if err != nil {
+		return nil, err
+	}
+	e.Text = text
+	if e.ParentID != 0 {
+		if err := tx.Get(&e.ParentUID, `SELECT uid FROM entry WHERE id = $1`, e.ParentID); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// loadTombstone returns the tombstone of the entry with the given uid or nil.
+func loadTombstone(tx *txn, uid string) (*tombstone, error) {
+	var t tombstone
+	if err := tx.Get(&t, `SELECT uid, version FROM entry_change WHERE uid = $1 AND deleted = 1`, uid); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// resolveParent returns the local id of the entry with the given uid, or zero
+// when it's empty or the entry hasn't been synced here, see setOrphan.
+func resolveParent(tx *txn, uid string) (int64, error) {
+	if uid == "" {
+		return 0, nil
+	}
+	var id int64
+	if err := tx.Get(&id, `SELECT id FROM entry WHERE uid = $1`, uid); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	return id, nil
+}
+
+// setOrphan records the uid of the parent of a reply when the parent hasn't
+// been synced here yet, so the reply can be attached once it is.
+func setOrphan(tx *txn, id, parent int64, parentUID string) error {
+	if parent != 0 || parentUID == "" {
+		_, err := tx.Exec(`DELETE FROM entry_orphan WHERE entry_id = $1`, id)
+		return err
+	}
+	_, err := tx.Exec(`INSERT OR REPLACE INTO entry_orphan (entry_id, parent_uid) VALUES ($1, $2)`, id, parentUID)
+	return err
+}
+
+// adoptOrphans attaches replies waiting for the entry with the given uid. The
+// replies already count their parent in their versions so they aren't bumped.
+func adoptOrphans(tx *txn, id int64, uid string) error {
+	if _, err := tx.Exec(`UPDATE entry SET parent_id = $1 WHERE id IN (SELECT entry_id FROM entry_orphan WHERE parent_uid = $2)`, id, uid); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM entry_orphan WHERE parent_uid = $1`, uid)
+	return err
+}
+
+func idOf(e *entry) int64 {
+	if e == nil {
+		return 0
+	}
+	return e.ID
+}
+
+// newer reports whether a wins a conflict with b, preferring the most
+// recently modified and breaking ties by content so both devices agree.
+func newer(a, b entry) bool {
+	if a.Modified != b.Modified {
+		return a.Modified > b.Modified
+	}
+	if a.Text != b.Text {
+		return a.Text > b.Text
+	}
+	if a.Color != b.Color {
+		return a.Color > b.Color
+	}
+	return a.Deleted > b.Deleted
+}
+
+// conflictUID derives the uid of the entry keeping the losing side of a
+// conflict from the entry's uid and the losing version.
+func conflictUID(e entry) string {
+	version, _ := json.Marshal(e.Version)
+	sum := sha256.Sum256([]byte(e.UID + string(version)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// compare returns how c is ordered relative to other.
+func (c clock) compare(other clock) int {
+	var before, after bool
+	for device, n := range c {
+		if n > other[device] {
+			after = true
+		} else if n < other[device] {
+			before = true
+		}
+	}
+	for device, n := range other {
+		if _, ok := c[device]; !ok && n > 0 {
+			before = true
+		}
+	}
+	switch {
+	case before && after:
+		return clockConcurrent
+	case before:
+		return clockBefore
+	case after:
+		return clockAfter
+	}
+	return clockEqual
+}
+
+// merge returns the version that has seen the changes of both c and other.
+func (c clock) merge(other clock) clock {
+	merged := clock{}
+	for device, n := range c {
+		merged[device] = n
+	}
+	for device, n := range other {
+		if n > merged[device] {
+			merged[device] = n
+		}
+	}
+	return merged
+}
+
+// Scan implements sql.Scanner, clocks are stored as JSON objects.
+func (c *clock) Scan(src interface{}) error {
+	*c = clock{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case []byte:
+		return json.Unmarshal(v, c)
+	}
+	return fmt.Errorf("unsupported clock type %T", src)
+}
+
+// Value implements driver.Valuer.
+func (c clock) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(c)
+	return string(data), err
+}
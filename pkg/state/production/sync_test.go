@@ -0,0 +1,133 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClockCompare(t *testing.T) {
+	for _, tt := range []struct {
+		a, b clock
+		want int
+	}{
+		{clock{}, clock{}, clockEqual},
+		{clock{"a": 1}, clock{"a": 1}, clockEqual},
+		{clock{"a": 1}, clock{"a": 2}, clockBefore},
+		{clock{"a": 1}, clock{"a": 1, "b": 1}, clockBefore},
+		{clock{"a": 2, "b": 1}, clock{"a": 1}, clockAfter},
+		{clock{"a": 2}, clock{"a": 1, "b": 1}, clockConcurrent},
+	} {
+		if got := tt.a.compare(tt.b); got != tt.want {
+			t.Errorf("%v.compare(%v) != %d (%d)", tt.a, tt.b, tt.want, got)
+		}
+	}
+	if merged := (clock{"a": 2, "b": 1}).merge(clock{"a": 1, "c": 3}); len(merged) != 3 || merged["a"] != 2 || merged["c"] != 3 {
+		t.Errorf("unexpected merge (%v)", merged)
+	}
+}
+
+func TestEntryVersion(t *testing.T) {
+	db := New(":memory:")
+	m := db.(*manager)
+	var device string
+	m.db.Get(&device, `SELECT value FROM setting WHERE key = 'device'`)
+
+	var s snapshot
+	json.Unmarshal(db.EntryCreate("one", 0), &s)
+	if e := s.Entries[0]; len(e.UID) != 32 || e.Version[device] != 1 {
+		t.Fatalf("unexpected uid and version (%+v)", e)
+	}
+	json.Unmarshal(db.EntryUpdate(1, "one again", 0), &s)
+	if v := s.Entries[0].Version[device]; v != 2 {
+		t.Errorf("expected update to count (%d)", v)
+	}
+
+	// Pinning is local and isn't counted.
+	json.Unmarshal(db.EntryPin(1), &s)
+	if v := s.Entries[0].Version[device]; v != 2 {
+		t.Errorf("expected pin not to count (%d)", v)
+	}
+
+	// Moving to and from the trash counts, undone or not.
+	db.EntryDelete(1)
+	db.Undo()
+	db.EntryDelete(1)
+	json.Unmarshal(db.EntryRestore(1), &s)
+	if v := s.Entries[0].Version[device]; v != 6 {
+		t.Errorf("expected trash changes to count (%d)", v)
+	}
+
+	// Resealing the text under a new key isn't a change.
+	db.SetPassphrase("", "hunter2")
+	json.Unmarshal(db.SetPassphrase("hunter2", ""), &s)
+	if v := s.Entries[0].Version[device]; v != 6 {
+		t.Errorf("expected resealing not to count (%d)", v)
+	}
+}
+
+func TestSyncApply(t *testing.T) {
+	db := New(":memory:")
+	var own changes
+	json.Unmarshal(db.ChangesSince("", 0), &own)
+
+	var summary syncSummary
+	if err := json.Unmarshal(db.SyncApply([]byte(`{"device": "`+own.Device+`"}`)), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Error == nil || summary.Error.Code != "InvalidChanges" {
+		t.Errorf("expected own changes to be rejected (%+v)", summary.Error)
+	}
+
+	// Entries deleted elsewhere aren't brought back by older versions.
+	json.Unmarshal(db.SyncApply([]byte(`{"device": "peer", "seq": 2, "tombstones": [{"uid": "abc", "version": {"peer": 2}}]}`)), &summary)
+	json.Unmarshal(db.SyncApply([]byte(`{"device": "other", "seq": 1, "entries": [{"uid": "abc", "text": "old", "version": {"peer": 1}}]}`)), &summary)
+	if summary.Skipped != 1 || summary.Inserted != 0 {
+		t.Errorf("expected deleted entry to stay deleted (%+v)", summary)
+	}
+	json.Unmarshal(db.SyncApply([]byte(`{"device": "other", "seq": 2, "entries": [{"uid": "abc", "text": "edited", "version": {"peer": 1, "other": 1}}]}`)), &summary)
+	if summary.Inserted != 1 {
+		t.Errorf("expected concurrent edit to be kept (%+v)", summary)
+	}
+
+	var cur syncCursor
+	json.Unmarshal(db.SyncCursor("peer"), &cur)
+	if cur.Seq != 2 || cur.Device != own.Device {
+		t.Errorf("unexpected cursor (%+v)", cur)
+	}
+}
+
+func TestSyncReplyBeforeParent(t *testing.T) {
+	src := New(":memory:")
+	src.EntryCreate("parent", 0)
+	src.EntryReply(1, "reply", 0)
+	src.EntryUpdate(1, "parent edited", 0)
+
+	// Editing the parent moves it after the reply in the feed.
+	var set changes
+	json.Unmarshal(src.ChangesSince("", 0), &set)
+	if len(set.Entries) != 2 || set.Entries[0].Text != "reply" {
+		t.Fatalf("expected reply first (%+v)", set.Entries)
+	}
+
+	// Whether the parent arrives in the same batch or a later one.
+	for _, batches := range [][]changes{
+		{set},
+		{{Device: set.Device, Epoch: set.Epoch, Seq: 2, Entries: set.Entries[:1]}, {Device: set.Device, Epoch: set.Epoch, Seq: 3, Entries: set.Entries[1:]}},
+	} {
+		dst := New(":memory:")
+		for _, batch := range batches {
+			data, _ := json.Marshal(batch)
+			dst.SyncApply(data)
+		}
+		var s snapshot
+		json.Unmarshal(dst.Thread(1), &s)
+		byText := map[string]entry{}
+		for _, e := range s.Entries {
+			byText[e.Text] = e
+		}
+		parent, reply := byText["parent edited"], byText["reply"]
+		if len(s.Entries) != 2 || parent.Replies != 1 || reply.ParentID != parent.ID {
+			t.Errorf("expected reply to be attached to parent (%+v)", s.Entries)
+		}
+	}
+}
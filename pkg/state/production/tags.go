@@ -0,0 +1,117 @@
+package production
+
+import (
+	"strings"
+)
+
+// tagUsage is a tag along with how often and when it has been used.
+type tagUsage struct {
+	tag
+	Count int64 `json:"count" db:"count"`
+	First int64 `json:"first" db:"first"` // earliest created time of a tagged entry
+	Last  int64 `json:"last" db:"last"`   // latest modified time of a tagged entry
+}
+
+// tagGroup is the list of tags sharing a namespace.
+type tagGroup struct {
+	Namespace string     `json:"namespace"`
+	Tags      []tagUsage `json:"tags"`
+}
+
+type catalog struct {
+	Namespaces []tagGroup `json:"namespaces"`
+	Error      *Error     `json:"error"`
+}
+
+// Tags returns every tag starting with the given prefix grouped by namespace.
+// The prefix is matched against the tag id, key and value so it can be used
+// to autocomplete tags; an empty prefix returns all tags.
+func (m *manager) Tags(prefix string) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var usages []tagUsage
+	pattern := escapeLike(strings.TrimPrefix(prefix, "#")) + "%"
+	if err := m.db.Select(&usages, `
+		SELECT tag.*,
+			count(DISTINCT entry.id) AS count,
+			min(entry.created) AS first,
+			max(entry.modified) AS last
+		FROM tag
+		JOIN entry_tag ON entry_tag.tag_id = tag.id
+		JOIN entry ON entry.id = entry_tag.entry_id AND entry.deleted = 0
+		WHERE tag.id LIKE $1 ESCAPE '\' OR tag.key LIKE $1 ESCAPE '\' OR tag.value LIKE $1 ESCAPE '\'
+		GROUP BY tag.id
+		ORDER BY tag.namespace, count DESC, tag.id`, pattern); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get tags: %s", err.Error()))
+	}
+	return encodeCatalog(usages)
+}
+
+// saveEntryTags replaces the indexed tags of an entry with those found in text.
+func saveEntryTags(tx *txn, id int64, text string) error {
+	if err := indexEntryTags(tx, id, text); err != nil {
+		return err
+	}
+	return deleteUnusedTags(tx)
+}
+
+func indexEntryTags(tx *txn, id int64, text string) error {
+	if _, err := tx.Exec(`DELETE FROM entry_tag WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	for i, tag := range encodeEntryTags(text) {
+		if _, err := tx.NamedExec(`INSERT OR IGNORE INTO tag (id, namespace, key, value) VALUES (:id, :namespace, :key, :value)`, tag); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO entry_tag (entry_id, position, tag_id) VALUES ($1, $2, $3)`, id, i, tag.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteEntryTags removes the indexed tags of a deleted entry.
+func deleteEntryTags(tx *txn, id int64) error {
+	if _, err := tx.Exec(`DELETE FROM entry_tag WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	return deleteUnusedTags(tx)
+}
+
+// deleteUnusedTags removes tags no longer attached to any entry.
+func deleteUnusedTags(tx *txn) error {
+	_, err := tx.Exec(`DELETE FROM tag WHERE NOT EXISTS (SELECT 1 FROM entry_tag WHERE entry_tag.tag_id = tag.id)`)
+	return err
+}
+
+// backfillTags indexes the tags of every entry, used when upgrading a
+// database created before tags were indexed.
+func backfillTags(tx *txn) error {
+	var entries []entry
+	if err := tx.Select(&entries, `SELECT id, text FROM entry`); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := indexEntryTags(tx, entry.ID, entry.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeCatalog(usages []tagUsage) []byte {
+	groups := []tagGroup{}
+	for _, usage := range usages {
+		if len(groups) == 0 || groups[len(groups)-1].Namespace != usage.Namespace {
+			groups = append(groups, tagGroup{Namespace: usage.Namespace})
+		}
+		group := &groups[len(groups)-1]
+		group.Tags = append(group.Tags, usage)
+	}
+	return encodeResponse(catalog{Namespaces: groups})
+}
+
+func escapeLike(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(str)
+}
@@ -0,0 +1,73 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEntryTags(t *testing.T) {
+	db := New(":memory:")
+	m := db.(*manager)
+	db.EntryCreate("buy milk #errands #home:list=groceries", 0)
+	db.EntryCreate("fix the build #work", 0)
+
+	var count int
+	if err := m.db.Get(&count, `SELECT count(*) FROM tag`); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("tags != 3 (%d)", count)
+	}
+
+	db.EntryUpdate(2, "fix the build #errands", 0)
+	var ids []string
+	if err := m.db.Select(&ids, `SELECT id FROM tag ORDER BY id`); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "errands" || ids[1] != "home:list=groceries" {
+		t.Errorf("unexpected tags after update (%v)", ids)
+	}
+
+	db.EntryDelete(1)
+	db.TrashEmpty()
+	if err := m.db.Get(&count, `SELECT count(*) FROM entry_tag`); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("entry tags != 1 (%d)", count)
+	}
+}
+
+func TestTags(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("buy milk #errands #home:list=groceries", 0)
+	db.EntryCreate("fix the build #work #errands", 0)
+	db.EntryCreate("fix the leak #home:plumbing", 0)
+
+	var c catalog
+	if err := json.Unmarshal(db.Tags(""), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Error != nil {
+		t.Fatal(c.Error.Error())
+	}
+	if len(c.Namespaces) != 2 {
+		t.Fatalf("namespaces != 2 (%+v)", c.Namespaces)
+	}
+	if c.Namespaces[0].Namespace != "" || len(c.Namespaces[0].Tags) != 2 {
+		t.Errorf("unexpected default namespace (%+v)", c.Namespaces[0])
+	}
+	if usage := c.Namespaces[0].Tags[0]; usage.ID != "errands" || usage.Count != 2 {
+		t.Errorf("unexpected most used tag (%+v)", usage)
+	}
+	if c.Namespaces[1].Namespace != "home" || len(c.Namespaces[1].Tags) != 2 {
+		t.Errorf("unexpected home namespace (%+v)", c.Namespaces[1])
+	}
+
+	if err := json.Unmarshal(db.Tags("#gro"), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Namespaces) != 1 || c.Namespaces[0].Tags[0].ID != "home:list=groceries" {
+		t.Errorf("unexpected prefix match (%+v)", c.Namespaces)
+	}
+}
@@ -0,0 +1,23 @@
+-- Schema created by releases before versioned migrations.
+CREATE TABLE IF NOT EXISTS entry (
+	id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+	text text NOT NULL,
+	color integer NOT NULL,
+	created integer NOT NULL,
+	modified integer NOT NULL
+);
+CREATE VIRTUAL TABLE IF NOT EXISTS entry_index USING fts5(text, tokenize=porter);
+CREATE TRIGGER IF NOT EXISTS after_entry_insert AFTER INSERT ON entry BEGIN
+	INSERT INTO entry_index (rowid, text) VALUES (new.id, new.text);
+END;
+CREATE TRIGGER IF NOT EXISTS after_entry_update AFTER UPDATE OF text ON entry BEGIN
+	UPDATE entry_index SET text = new.text WHERE rowid = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS after_entry_insert AFTER DELETE ON entry BEGIN
+	DELETE FROM entry_index WHERE rowid = old.id;
+END;
+
+INSERT INTO entry (text, color, created, modified) VALUES ('buy milk #errands', 1, 1614556800, 1614556800);
+INSERT INTO entry (text, color, created, modified) VALUES ('deleted note', 0, 1614643200, 1614643200);
+INSERT INTO entry (text, color, created, modified) VALUES ('fix the build #work #errands', 2, 1614729600, 1614729600);
+DELETE FROM entry WHERE id = 2;
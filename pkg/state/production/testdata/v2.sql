@@ -0,0 +1,22 @@
+-- Schema version 2, before tags were indexed.
+CREATE TABLE entry (
+	id integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+	text text NOT NULL,
+	color integer NOT NULL,
+	created integer NOT NULL,
+	modified integer NOT NULL
+);
+CREATE VIRTUAL TABLE entry_index USING fts5(text, tokenize=porter);
+CREATE TRIGGER after_entry_insert AFTER INSERT ON entry BEGIN
+	INSERT INTO entry_index (rowid, text) VALUES (new.id, new.text);
+END;
+CREATE TRIGGER after_entry_update AFTER UPDATE OF text ON entry BEGIN
+	UPDATE entry_index SET text = new.text WHERE rowid = old.id;
+END;
+CREATE TRIGGER after_entry_delete AFTER DELETE ON entry BEGIN
+	DELETE FROM entry_index WHERE rowid = old.id;
+END;
+PRAGMA user_version = 2;
+
+INSERT INTO entry (text, color, created, modified) VALUES ('buy milk #errands', 1, 1614556800, 1614556800);
+INSERT INTO entry (text, color, created, modified) VALUES ('fix the build #work #errands', 2, 1614729600, 1614729600);
@@ -0,0 +1,93 @@
+package production
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// EntryReply creates a new entry replying to the given parent entry.
+func (m *manager) EntryReply(parent int64, text string, color int64) []byte {
+	err := m.transact(func(tx *txn) error {
+		if err := requireEntry(tx, parent); err != nil {
+			return err
+		}
+		_, err := createEntry(tx, text, color, parent)
+		return err
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to reply"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// Thread returns the thread containing the given entry, starting with the
+// entry the thread replies to followed by every reply, oldest first. Replies
+// to entries in the trash are still included.
+func (m *manager) Thread(id int64) []byte {
+	if err := m.unlocked(); err != nil {
+		return encodeError(err)
+	}
+	var entries []entry
+	err := requireEntry(m.db, id)
+	if err == nil {
+		err = m.db.Select(&entries, `
+			WITH RECURSIVE
+				ancestor(id, parent_id) AS (
+					SELECT id, parent_id FROM entry WHERE id = $1
+					UNION
+					SELECT entry.id, entry.parent_id FROM entry JOIN ancestor ON entry.id = ancestor.parent_id
+				),
+				root(id) AS (
+					SELECT id FROM ancestor
+					WHERE parent_id = 0 OR parent_id NOT IN (SELECT id FROM entry)
+					LIMIT 1
+				),
+				thread(id) AS (
+					SELECT id FROM root
+					UNION
+					SELECT entry.id FROM entry JOIN thread ON entry.parent_id = thread.id
+				)
+			SELECT * FROM entry
+			WHERE id IN (SELECT id FROM thread) AND deleted = 0
+			ORDER BY created, id`, id)
+	}
+	if err != nil {
+		return encodeError(wrapError(err, "failed to get thread"))
+	}
+	if err := m.loadDetails(entries); err != nil {
+		return encodeError(wrapError(err, "failed to get entry details"))
+	}
+	return encodeResponse(encodeSnapshot(entries, ""))
+}
+
+// loadReplyCounts sets the number of current replies to the given entries.
+func (m *manager) loadReplyCounts(entries []entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	query, args, err := sqlx.In(`
+		SELECT parent_id, count(*) AS count FROM entry
+		WHERE deleted = 0 AND parent_id IN (?)
+		GROUP BY parent_id`, ids)
+	if err != nil {
+		return err
+	}
+	var counts []struct {
+		ParentID int64 `db:"parent_id"`
+		Count    int64 `db:"count"`
+	}
+	if err := m.db.Select(&counts, query, args...); err != nil {
+		return err
+	}
+	byParent := make(map[int64]int64)
+	for _, c := range counts {
+		byParent[c.ParentID] = c.Count
+	}
+	for i := range entries {
+		entries[i].Replies = byParent[entries[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestThread(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("idea", 0)
+	db.EntryCreate("unrelated", 0)
+	db.EntryReply(1, "follow up", 0)
+	db.EntryReply(3, "follow up on the follow up", 0)
+	db.EntryReply(1, "another thought", 0)
+
+	var s snapshot
+	if err := json.Unmarshal(db.Current(), &s); err != nil {
+		t.Fatal(err)
+	}
+	replies := map[int64]int64{}
+	parents := map[int64]int64{}
+	for _, e := range s.Entries {
+		replies[e.ID] = e.Replies
+		parents[e.ID] = e.ParentID
+	}
+	if replies[1] != 2 || replies[3] != 1 || replies[2] != 0 {
+		t.Errorf("unexpected reply counts (%v)", replies)
+	}
+	if parents[3] != 1 || parents[4] != 3 || parents[1] != 0 {
+		t.Errorf("unexpected parents (%v)", parents)
+	}
+
+	for _, id := range []int64{1, 4} {
+		if err := json.Unmarshal(db.Thread(id), &s); err != nil {
+			t.Fatal(err)
+		}
+		if ids := entryIDs(s.Entries); len(ids) != 4 || ids[0] != 1 || ids[1] != 3 || ids[2] != 4 || ids[3] != 5 {
+			t.Errorf("unexpected thread of %d (%v)", id, ids)
+		}
+	}
+
+	if err := json.Unmarshal(db.EntryReply(9, "orphan", 0), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "NotFound" {
+		t.Errorf("expected NotFound error (%+v)", s.Error)
+	}
+}
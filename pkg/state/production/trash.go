@@ -0,0 +1,134 @@
+package production
+
+import (
+	"strconv"
+	"time"
+)
+
+// defaultTrashRetention is the number of days entries stay in the trash
+// before they're purged unless configured otherwise.
+const defaultTrashRetention = 30
+
+// Trash returns the entries in the trash, most recently deleted first.
+func (m *manager) Trash() []byte {
+	var entries []entry
+	if err := m.db.Select(&entries, `SELECT * FROM entry WHERE deleted > 0 ORDER BY deleted DESC, id DESC`); err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to get trash: %s", err.Error()))
+	}
+	if err := m.loadDetails(entries); err != nil {
+		return encodeError(wrapError(err, "failed to get entry details"))
+	}
+	return encodePage(entries, 0, 0)
+}
+
+// EntryRestore moves an entry out of the trash.
+func (m *manager) EntryRestore(id int64) []byte {
+	err := m.transact(func(tx *txn) error {
+		return journaled(tx, journalRestore, id, func() error {
+			res, err := tx.Exec(`UPDATE entry SET deleted = 0 WHERE id = $1 AND deleted > 0`, id)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				return ErrorNotFound("entry %d not in trash", id)
+			}
+			return bumpVersion(tx, id)
+		})
+	})
+	if err != nil {
+		return encodeError(wrapError(err, "failed to restore entry"))
+	}
+	return m.CurrentPage("", pageLimit)
+}
+
+// TrashEmpty permanently deletes every entry in the trash.
+func (m *manager) TrashEmpty() []byte {
+	err := m.transact(func(tx *txn) error {
+		return purgeEntries(tx, `deleted > 0`)
+	})
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to empty trash: %s", err.Error()))
+	}
+	return m.Trash()
+}
+
+// SetTrashRetention sets the number of days entries stay in the trash before
+// they're permanently deleted, zero keeps them forever. Entries older than
+// the new retention period are purged immediately.
+func (m *manager) SetTrashRetention(days int64) []byte {
+	if days < 0 {
+		days = 0
+	}
+	err := m.transact(func(tx *txn) error {
+		if err := setSetting(tx, settingTrashRetention, strconv.FormatInt(days, 10)); err != nil {
+			return err
+		}
+		return purgeTrash(tx)
+	})
+	if err != nil {
+		return encodeError(ErrorProgrammerFailure("failed to set trash retention: %s", err.Error()))
+	}
+	return m.Trash()
+}
+
+// purgeTrash permanently deletes entries that have been in the trash for
+// longer than the retention period.
+func purgeTrash(tx *txn) error {
+	days, err := settingInt(tx, settingTrashRetention, defaultTrashRetention)
+	if err != nil || days == 0 {
+		return err
+	}
+	before := time.Now().AddDate(0, 0, -int(days)).Unix()
+	return purgeEntries(tx, `deleted > 0 AND deleted < ?`, before)
+}
+
+// purgeEntries permanently deletes the entries matching the given condition
+// along with everything stored about them.
+func purgeEntries(tx *txn, where string, args ...interface{}) error {
+	var ids []int64
+	if err := tx.Select(&ids, `SELECT id FROM entry WHERE `+where, args...); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := removeEntry(tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM journal WHERE entry_id = $1`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEntry deletes an entry along with its revisions and tags.
+func removeEntry(tx *txn, id int64) error {
+	if _, err := tx.Exec(`DELETE FROM entry WHERE id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM entry_revision WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM entry_date WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM entry_link WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM entry_reference WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM entry_orphan WHERE entry_id = $1`, id); err != nil {
+		return err
+	}
+	if err := deleteEntryAttachments(tx, id); err != nil {
+		return err
+	}
+	if tx.box != nil {
+		if _, err := tx.Exec(`DELETE FROM entry_index WHERE rowid = $1`, id); err != nil {
+			return err
+		}
+	}
+	return deleteEntryTags(tx, id)
+}
@@ -0,0 +1,86 @@
+package production
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("buy milk #errands", 0)
+	db.EntryCreate("fix the build #work", 0)
+
+	var s snapshot
+	if err := json.Unmarshal(db.EntryDelete(1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 1 {
+		t.Errorf("entries != 1 (%d)", len(s.Entries))
+	}
+	if err := json.Unmarshal(db.EntrySearch("milk"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 0 {
+		t.Errorf("trashed entry found by search")
+	}
+	if err := json.Unmarshal(db.Trash(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 1 || s.Entries[0].Deleted == 0 {
+		t.Fatalf("unexpected trash (%+v)", s.Entries)
+	}
+
+	// Entries in the trash can't be changed.
+	for name, data := range map[string][]byte{
+		"EntryUpdate":     db.EntryUpdate(1, "buy oat milk", 0),
+		"EntryRevert":     db.EntryRevert(1, 1),
+		"EntryToggleItem": db.EntryToggleItem(1, 0),
+	} {
+		if err := json.Unmarshal(data, &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Error == nil || s.Error.Code != "NotFound" {
+			t.Errorf("expected %s to fail with NotFound (%+v)", name, s.Error)
+		}
+	}
+
+	if err := json.Unmarshal(db.EntryRestore(1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 2 {
+		t.Errorf("entry not restored (%d)", len(s.Entries))
+	}
+
+	db.EntryDelete(1)
+	if err := json.Unmarshal(db.TrashEmpty(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 0 {
+		t.Errorf("trash not emptied (%d)", len(s.Entries))
+	}
+	if err := json.Unmarshal(db.EntryRestore(1), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Error == nil || s.Error.Code != "NotFound" {
+		t.Errorf("expected NotFound error (%+v)", s.Error)
+	}
+}
+
+func TestTrashRetention(t *testing.T) {
+	db := New(":memory:")
+	db.EntryCreate("old", 0)
+	db.EntryCreate("recent", 0)
+	db.EntryDelete(1)
+	db.EntryDelete(2)
+	old := time.Now().AddDate(0, 0, -10).Unix()
+	db.(*manager).db.MustExec(`UPDATE entry SET deleted = $1 WHERE id = 1`, old)
+
+	var s snapshot
+	if err := json.Unmarshal(db.SetTrashRetention(7), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 1 || s.Entries[0].ID != 2 {
+		t.Errorf("expected only recent entry in trash (%+v)", s.Entries)
+	}
+}
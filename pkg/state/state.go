@@ -5,12 +5,75 @@ import "log"
 // Stater defines the state interface.
 type Stater interface {
 	Current() []byte
+	CurrentPage(cursor string, limit int64) []byte
 	EntryCreate(text string, color int64) []byte
 	EntryUpdate(id int64, text string, color int64) []byte
 	EntryDelete(id int64) []byte
 	EntrySearch(query string) []byte
+	EntrySearchPage(query string, cursor string, limit int64) []byte
+	EntrySearchRanked(query string, ranking string, cursor string, limit int64) []byte
+	Tags(prefix string) []byte
+	EntryRevisions(id int64) []byte
+	EntryRevert(id int64, revision int64) []byte
+	Trash() []byte
+	EntryRestore(id int64) []byte
+	TrashEmpty() []byte
+	SetTrashRetention(days int64) []byte
+	Undo() []byte
+	Redo() []byte
+	SetUndoDepth(depth int64) []byte
+	EntryPin(id int64) []byte
+	EntryUnpin(id int64) []byte
+	EntryToggleItem(id int64, index int64) []byte
+	Overdue() []byte
+	DueToday() []byte
+	Upcoming(days int64) []byte
+	EntryAttach(id int64, data []byte, mime string) []byte
+	EntryAttachments(id int64) []byte
+	EntryAttachment(id int64, hash string) []byte
+	EntryDetach(id int64, hash string) []byte
+	Links() []byte
+	LinkHosts() []byte
+	EntriesLinkingTo(host string) []byte
+	EntryReferences(id int64) []byte
+	EntryBacklinks(id int64) []byte
+	EntryReply(parent int64, text string, color int64) []byte
+	Thread(id int64) []byte
+	EntriesImport(data []byte) []byte
+	EncryptionState() []byte
+	SetPassphrase(current string, passphrase string) []byte
+	Unlock(passphrase string) []byte
+	Lock() []byte
+	Backup(path string) []byte
+	Restore(path string) []byte
+	Backups() []byte
+	SetBackupCount(count int64) []byte
+	ChangesSince(epoch string, seq int64) []byte
+	SyncCursor(peer string) []byte
+	SyncApply(data []byte) []byte
+	Observe(o Observer) int64
+	Unobserve(id int64)
 }
 
+// Observer is implemented by clients to learn about changes to entries,
+// whichever code path or process made them. EntriesChanged receives JSON
+// listing the ids of the changed entries and the kind of each change:
+// create, update or delete. When reset is set instead everything should be
+// reloaded.
+type Observer interface {
+	EntriesChanged(data []byte)
+}
+
+// Search rankings accepted by Stater.EntrySearchRanked.
+const (
+	// RankChronological orders matches newest first.
+	RankChronological = "chronological"
+	// RankRelevance orders matches by how well they match the query.
+	RankRelevance = "relevance"
+	// RankRecency orders matches by relevance, boosting recent entries.
+	RankRecency = "recency"
+)
+
 // Backend represents a state backend that can be instantiated.
 type Backend func(string) Stater
 
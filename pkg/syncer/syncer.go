@@ -0,0 +1,307 @@
+// Package syncer keeps two Logger databases in step over HTTP. One database
+// serves its change feed with Handler and the other runs Sync against it,
+// pulling the server's changes and then pushing its own. Both sides record
+// how far they've got through the other's changes as each batch is applied,
+// so an interrupted sync picks up where it left off. Conflicts are resolved
+// by the Stater, see SyncApply.
+package syncer
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nathanborror/logger/pkg/state"
+)
+
+// ErrNoToken is returned when serving changes without a token.
+var ErrNoToken = errors.New("sync token is empty")
+
+// ErrInsecure is returned when serving changes beyond this device without
+// TLS.
+var ErrInsecure = errors.New("serving changes beyond loopback requires TLS")
+
+// maxBatchSize is the largest change set the server accepts.
+const maxBatchSize = 64 << 20
+
+// Options configure a sync.
+type Options struct {
+	// Token is sent as a bearer token and must match the server's.
+	Token string
+	// Client makes the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// Summary counts the changes applied on each side.
+type Summary struct {
+	Peer   string `json:"peer"`
+	Pulled Counts `json:"pulled"`
+	Pushed Counts `json:"pushed"`
+}
+
+// Counts tallies the outcome of applying changes from a peer.
+type Counts struct {
+	Inserted  int64 `json:"inserted"`
+	Updated   int64 `json:"updated"`
+	Deleted   int64 `json:"deleted"`
+	Conflicts int64 `json:"conflicts"`
+	Skipped   int64 `json:"skipped"`
+}
+
+func (c *Counts) add(o Counts) {
+	c.Inserted += o.Inserted
+	c.Updated += o.Updated
+	c.Deleted += o.Deleted
+	c.Conflicts += o.Conflicts
+	c.Skipped += o.Skipped
+}
+
+// stateError matches the error of Stater responses.
+type stateError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// feed matches the parts of Stater.ChangesSince needed to page through it.
+type feed struct {
+	Device string      `json:"device"`
+	Epoch  string      `json:"epoch"`
+	Seq    int64       `json:"seq"`
+	More   bool        `json:"more"`
+	Reset  bool        `json:"reset"`
+	Error  *stateError `json:"error"`
+}
+
+// cursor matches Stater.SyncCursor.
+type cursor struct {
+	Device string      `json:"device"`
+	Epoch  string      `json:"epoch"`
+	Seq    int64       `json:"seq"`
+	Error  *stateError `json:"error"`
+}
+
+// applied matches Stater.SyncApply.
+type applied struct {
+	Counts
+	Error *stateError `json:"error"`
+}
+
+// Handler serves the changes of s to peers and applies the changes they
+// push. Requests must carry token as a bearer token. Every request is refused
+// when token is empty so a missing token never leaves the database open.
+//
+//	GET  /cursor?peer=ID            the last change received from peer
+//	GET  /changes?epoch=E&since=N   changes after N in epoch E
+//	POST /changes                   apply changes from a peer
+func Handler(s state.Stater, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cursor", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		respond(w, s.SyncCursor(r.URL.Query().Get("peer")))
+	})
+	mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+			respond(w, s.ChangesSince(r.URL.Query().Get("epoch"), since))
+		case http.MethodPost:
+			data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchSize))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			respond(w, s.SyncApply(data))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe serves Handler at addr until it fails. Changes carry the
+// plain text of every entry, even from encrypted databases, along with the
+// token, so they're served over TLS using certFile and keyFile. Without them
+// addr must be a loopback address.
+func ListenAndServe(s state.Stater, addr, token, certFile, keyFile string) error {
+	if token == "" {
+		return ErrNoToken
+	}
+	if certFile != "" || keyFile != "" {
+		return http.ListenAndServeTLS(addr, certFile, keyFile, Handler(s, token))
+	}
+	if !loopback(addr) {
+		return ErrInsecure
+	}
+	return http.ListenAndServe(addr, Handler(s, token))
+}
+
+// loopback reports whether addr only listens on this device.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func respond(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Sync exchanges changes between s and the server at base, pulling first so
+// conflicts are resolved here before pushing.
+func Sync(s state.Stater, base string, opts Options) (*Summary, error) {
+	c := &client{base: strings.TrimSuffix(base, "/"), opts: opts}
+	if c.opts.Client == nil {
+		c.opts.Client = http.DefaultClient
+	}
+
+	var local cursor
+	if err := decode(s.SyncCursor(""), &local, &local.Error); err != nil {
+		return nil, err
+	}
+	var remote cursor
+	if err := c.get("/cursor?peer="+url.QueryEscape(local.Device), &remote, &remote.Error); err != nil {
+		return nil, err
+	}
+	if err := decode(s.SyncCursor(remote.Device), &local, &local.Error); err != nil {
+		return nil, err
+	}
+	summary := &Summary{Peer: remote.Device}
+
+	// Pull the server's changes.
+	for epoch, since := local.Epoch, local.Seq; ; {
+		data, f, err := c.changes(epoch, since)
+		if err != nil {
+			return summary, err
+		}
+		if f.Seq != since || f.Reset {
+			var res applied
+			if err := decode(s.SyncApply(data), &res, &res.Error); err != nil {
+				return summary, err
+			}
+			summary.Pulled.add(res.Counts)
+		}
+		if epoch, since = f.Epoch, f.Seq; !f.More {
+			break
+		}
+	}
+
+	// Push changes the server hasn't seen.
+	for epoch, since := remote.Epoch, remote.Seq; ; {
+		data := s.ChangesSince(epoch, since)
+		var f feed
+		if err := decode(data, &f, &f.Error); err != nil {
+			return summary, err
+		}
+		if f.Seq != since || f.Reset {
+			var res applied
+			if err := c.post("/changes", data, &res, &res.Error); err != nil {
+				return summary, err
+			}
+			summary.Pushed.add(res.Counts)
+		}
+		if epoch, since = f.Epoch, f.Seq; !f.More {
+			break
+		}
+	}
+	return summary, nil
+}
+
+type client struct {
+	base string
+	opts Options
+}
+
+func (c *client) changes(epoch string, since int64) ([]byte, *feed, error) {
+	query := url.Values{"epoch": {epoch}, "since": {strconv.FormatInt(since, 10)}}
+	data, err := c.do(http.MethodGet, "/changes?"+query.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var f feed
+	if err := decode(data, &f, &f.Error); err != nil {
+		return nil, nil, err
+	}
+	return data, &f, nil
+}
+
+func (c *client) get(path string, v interface{}, e **stateError) error {
+	data, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return decode(data, v, e)
+}
+
+func (c *client) post(path string, body []byte, v interface{}, e **stateError) error {
+	data, err := c.do(http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	return decode(data, v, e)
+}
+
+func (c *client) do(method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// decode unmarshals a Stater response into v, returning the error it holds.
+func decode(data []byte, v interface{}, e **stateError) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if *e != nil {
+		return fmt.Errorf("%s: %s", (*e).Code, (*e).Message)
+	}
+	return nil
+}
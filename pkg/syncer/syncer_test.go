@@ -0,0 +1,327 @@
+package syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nathanborror/logger/pkg/state"
+	"github.com/nathanborror/logger/pkg/state/production"
+)
+
+type snapshot struct {
+	Entries []struct {
+		ID   int64  `json:"id"`
+		UID  string `json:"uid"`
+		Text string `json:"text"`
+	} `json:"entries"`
+	More bool `json:"more"`
+}
+
+// contents returns the uid and stored text of every entry in s.
+func contents(t *testing.T, s state.Stater) []string {
+	var out []string
+	var (
+		epoch string
+		since int64
+	)
+	for {
+		var page struct {
+			snapshot
+			Epoch string `json:"epoch"`
+			Seq   int64  `json:"seq"`
+		}
+		if err := json.Unmarshal(s.ChangesSince(epoch, since), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range page.Entries {
+			out = append(out, e.UID+" "+e.Text)
+		}
+		if epoch, since = page.Epoch, page.Seq; !page.More {
+			break
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func current(t *testing.T, s state.Stater) snapshot {
+	var snap snapshot
+	if err := json.Unmarshal(s.Current(), &snap); err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+func converged(t *testing.T, a, b state.Stater) {
+	t.Helper()
+	ca, cb := contents(t, a), contents(t, b)
+	if strings.Join(ca, "\n") != strings.Join(cb, "\n") {
+		t.Fatalf("databases differ\n%v\n%v", ca, cb)
+	}
+}
+
+func serve(t *testing.T, s state.Stater, token string) string {
+	srv := httptest.NewServer(Handler(s, token))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestSync(t *testing.T) {
+	laptop := production.New(":memory:")
+	phone := production.New(":memory:")
+	url := serve(t, laptop, "secret")
+	opts := Options{Token: "secret"}
+
+	laptop.EntryCreate("from the laptop #work", 0)
+	phone.EntryCreate("from the phone", 0)
+	summary, err := Sync(phone, url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pulled.Inserted != 1 || summary.Pushed.Inserted != 1 {
+		t.Errorf("unexpected summary (%+v)", summary)
+	}
+	converged(t, laptop, phone)
+	if n := len(current(t, phone).Entries); n != 2 {
+		t.Errorf("expected 2 entries on the phone (%d)", n)
+	}
+
+	// Nothing changes on a second sync.
+	if summary, err = Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pulled.Inserted+summary.Pulled.Updated+summary.Pushed.Inserted+summary.Pushed.Updated != 0 {
+		t.Errorf("unexpected changes on second sync (%+v)", summary)
+	}
+
+	// Changes on one side replace the other.
+	phoneID := current(t, phone).Entries[0].ID
+	phone.EntryUpdate(phoneID, "edited on the phone", 0)
+	if summary, err = Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pushed.Updated != 1 || summary.Pushed.Conflicts != 0 {
+		t.Errorf("unexpected summary (%+v)", summary)
+	}
+	converged(t, laptop, phone)
+
+	// Permanent deletions propagate.
+	laptopID := current(t, laptop).Entries[0].ID
+	laptop.EntryDelete(laptopID)
+	laptop.TrashEmpty()
+	if summary, err = Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pulled.Deleted != 1 {
+		t.Errorf("unexpected summary (%+v)", summary)
+	}
+	converged(t, laptop, phone)
+	if n := len(current(t, phone).Entries); n != 1 {
+		t.Errorf("expected 1 entry on the phone (%d)", n)
+	}
+
+	if _, err := Sync(phone, url, Options{Token: "wrong"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized error (%v)", err)
+	}
+}
+
+func TestHandlerWithoutToken(t *testing.T) {
+	url := serve(t, production.New(":memory:"), "")
+	for _, token := range []string{"", "anything"} {
+		if _, err := Sync(production.New(":memory:"), url, Options{Token: token}); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("expected unauthorized error with token %q (%v)", token, err)
+		}
+	}
+}
+
+func TestListenAndServeInsecure(t *testing.T) {
+	db := production.New(":memory:")
+	if err := ListenAndServe(db, "127.0.0.1:0", "", "", ""); err != ErrNoToken {
+		t.Errorf("expected ErrNoToken (%v)", err)
+	}
+	for _, addr := range []string{":0", "0.0.0.0:0", "192.168.1.2:0", "example.com:0"} {
+		if err := ListenAndServe(db, addr, "secret", "", ""); err != ErrInsecure {
+			t.Errorf("%s: expected ErrInsecure (%v)", addr, err)
+		}
+	}
+	for _, addr := range []string{"127.0.0.1:0", "localhost:0", "[::1]:0"} {
+		if !loopback(addr) {
+			t.Errorf("%s: expected loopback", addr)
+		}
+	}
+}
+
+func TestSyncAfterRestore(t *testing.T) {
+	dir := t.TempDir()
+	laptop := production.New(filepath.Join(dir, "laptop.logger"))
+	phone := production.New(":memory:")
+	url := serve(t, laptop, "secret")
+	opts := Options{Token: "secret"}
+
+	laptop.EntryCreate("one", 0)
+	backup := filepath.Join(dir, "backup.logger")
+	laptop.Backup(backup)
+	laptop.EntryCreate("two", 0)
+	if _, err := Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restored laptop reuses sequence numbers the phone has already seen.
+	laptop.Restore(backup)
+	laptop.EntryCreate("three", 0)
+	if _, err := Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, e := range current(t, phone).Entries {
+		texts = append(texts, e.Text)
+	}
+	if len(texts) != 3 || texts[0] != "three" {
+		t.Errorf("expected entry made after restore to be pulled (%q)", texts)
+	}
+	converged(t, laptop, phone)
+}
+
+func TestSyncUndoRedo(t *testing.T) {
+	laptop := production.New(":memory:")
+	phone := production.New(":memory:")
+	url := serve(t, laptop, "secret")
+	opts := Options{Token: "secret"}
+
+	phone.EntryCreate("note", 0)
+	if _, err := Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	phone.Undo()
+	phone.Redo()
+	if _, err := Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	converged(t, laptop, phone)
+	if n := len(current(t, laptop).Entries); n != 1 {
+		t.Errorf("expected the note to return once (%d entries)", n)
+	}
+}
+
+func TestSyncConflict(t *testing.T) {
+	laptop := production.New(":memory:")
+	phone := production.New(":memory:")
+	url := serve(t, laptop, "secret")
+	opts := Options{Token: "secret"}
+
+	laptop.EntryCreate("shopping list", 0)
+	if _, err := Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	laptop.EntryUpdate(current(t, laptop).Entries[0].ID, "shopping list: milk", 0)
+	phone.EntryUpdate(current(t, phone).Entries[0].ID, "shopping list: eggs", 0)
+
+	summary, err := Sync(phone, url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pulled.Conflicts != 1 {
+		t.Errorf("expected a conflict (%+v)", summary)
+	}
+	converged(t, laptop, phone)
+	var texts []string
+	for _, e := range contents(t, laptop) {
+		texts = append(texts, e[strings.Index(e, " ")+1:])
+	}
+	sort.Strings(texts)
+	if len(texts) != 2 || !strings.HasPrefix(texts[0], "shopping list: eggs") || !strings.HasPrefix(texts[1], "shopping list: milk") ||
+		strings.Contains(texts[0], "#conflict") == strings.Contains(texts[1], "#conflict") {
+		t.Fatalf("expected both versions to be kept (%q)", texts)
+	}
+
+	// Both devices resolve the conflict the same way, a third sync is quiet.
+	if summary, err = Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pulled.Conflicts+summary.Pushed.Conflicts+summary.Pulled.Inserted+summary.Pushed.Inserted != 0 {
+		t.Errorf("unexpected changes after resolving (%+v)", summary)
+	}
+	converged(t, laptop, phone)
+}
+
+func TestSyncEditWinsOverDelete(t *testing.T) {
+	laptop := production.New(":memory:")
+	phone := production.New(":memory:")
+	url := serve(t, laptop, "secret")
+	opts := Options{Token: "secret"}
+
+	laptop.EntryCreate("keep me", 0)
+	if _, err := Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	laptop.EntryDelete(current(t, laptop).Entries[0].ID)
+	laptop.TrashEmpty()
+	phone.EntryUpdate(current(t, phone).Entries[0].ID, "keep me, edited", 0)
+
+	if _, err := Sync(phone, url, opts); err != nil {
+		t.Fatal(err)
+	}
+	converged(t, laptop, phone)
+	if entries := current(t, laptop).Entries; len(entries) != 1 || entries[0].Text != "keep me, edited" {
+		t.Errorf("expected edit to survive (%+v)", entries)
+	}
+}
+
+// failingTransport fails every request after the first n.
+type failingTransport struct {
+	n int
+}
+
+func (f *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if f.n == 0 {
+		return nil, errors.New("connection lost")
+	}
+	f.n--
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSyncResume(t *testing.T) {
+	laptop := production.New(":memory:")
+	phone := production.New(":memory:")
+	url := serve(t, laptop, "secret")
+	opts := Options{Token: "secret"}
+
+	var records []string
+	for i := 0; i < 700; i++ {
+		records = append(records, fmt.Sprintf(`{"text": "entry %d", "created": %d}`, i, 1600000000+i))
+	}
+	laptop.EntriesImport([]byte("[" + strings.Join(records, ",") + "]"))
+
+	// The connection drops after the first batch of changes is pulled.
+	_, err := Sync(phone, url, Options{Token: "secret", Client: &http.Client{Transport: &failingTransport{n: 2}}})
+	if err == nil {
+		t.Fatal("expected sync to fail")
+	}
+	var cur struct {
+		Seq int64 `json:"seq"`
+	}
+	var device struct {
+		Device string `json:"device"`
+	}
+	json.Unmarshal(laptop.SyncCursor(""), &device)
+	json.Unmarshal(phone.SyncCursor(device.Device), &cur)
+	if cur.Seq == 0 {
+		t.Fatal("expected first batch to be recorded")
+	}
+
+	summary, err := Sync(phone, url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Pulled.Inserted != 200 {
+		t.Errorf("expected sync to resume (%+v)", summary)
+	}
+	converged(t, laptop, phone)
+}
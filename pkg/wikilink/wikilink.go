@@ -0,0 +1,36 @@
+// Package wikilink parses links between notes written as [[target]], where
+// the target is either the id of a note or its title. A note's title is the
+// first line of its text.
+package wikilink
+
+import (
+	"regexp"
+	"strings"
+)
+
+var reLink = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// Parse returns the targets of the links in text in order.
+func Parse(text string) []string {
+	var targets []string
+	for _, m := range reLink.FindAllStringSubmatch(text, -1) {
+		if target := strings.TrimSpace(m[1]); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// TitleSQL returns an SQL expression for the title of the note text in the
+// given expression, matching how link targets are compared.
+func TitleSQL(text string) string {
+	return `trim(substr(` + text + `, 1, instr(` + text + ` || char(10), char(10)) - 1), ' ' || char(9) || char(13))`
+}
+
+// Title returns the title of the note text, matching TitleSQL.
+func Title(text string) string {
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return strings.Trim(text, " \t\r")
+}
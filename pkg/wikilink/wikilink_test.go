@@ -0,0 +1,23 @@
+package wikilink
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	targets := Parse("see [[1234]] and [[ Some Title ]], not [[]] or [[a\nb]] or [[[x]]")
+	expected := []string{"1234", "Some Title", "x"}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("expected %q, got %q", expected, targets)
+	}
+	if targets := Parse("no links"); targets != nil {
+		t.Errorf("expected no links (%q)", targets)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	if title := Title(" Some Title \r\nbody"); title != "Some Title" {
+		t.Errorf("unexpected title (%q)", title)
+	}
+}